@@ -1,11 +1,7 @@
 package main
 
 import (
-	"context"
-	"log/slog"
-	"time"
-
-	"github.com/arista-northwest/go-passpersist/passpersist"
+	_ "github.com/arista-northwest/go-passpersist/extensions/hello"
 	"github.com/arista-northwest/go-passpersist/utils"
 )
 
@@ -20,26 +16,5 @@ func init() {
 }
 
 func main() {
-	defer utils.CapPanic()
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	utils.CommonCLI(version, tag, date)
-
-	var opts []passpersist.Option
-
-	b, _ := utils.GetBaseOIDFromSNMPdConfig()
-	if b != nil {
-		opts = append(opts, passpersist.WithBaseOID(*b))
-	}
-	opts = append(opts, passpersist.WithRefresh(time.Second*300))
-
-	pp := passpersist.NewPassPersist(opts...)
-
-	pp.Run(ctx, func(pp *passpersist.PassPersist) {
-		slog.Debug("updating...")
-		pp.AddString([]int{0}, "Hello from PassPersist")
-		pp.AddString([]int{1}, "You found a secret message!")
-	})
+	utils.RunExtension("hello", version, tag, date)
 }
@@ -0,0 +1,31 @@
+// Command passpersist bundles every extension in one binary. Symlink it to
+// an extension name or pass the name as the first argument:
+//
+//	ln -s passpersist /mnt/flash/vrf
+//	passpersist vrf -console
+package main
+
+import (
+	"log/slog"
+	"log/syslog"
+
+	_ "github.com/arista-northwest/go-passpersist/extensions/dhcprelay"
+	_ "github.com/arista-northwest/go-passpersist/extensions/hello"
+	_ "github.com/arista-northwest/go-passpersist/extensions/vrf"
+	"github.com/arista-northwest/go-passpersist/utils"
+	"github.com/arista-northwest/go-passpersist/utils/logger"
+)
+
+var (
+	date    string
+	tag     string
+	version string
+)
+
+func init() {
+	logger.EnableSyslogger(syslog.LOG_LOCAL4, slog.LevelInfo)
+}
+
+func main() {
+	utils.Multicall(version, tag, date)
+}
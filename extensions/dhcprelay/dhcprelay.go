@@ -0,0 +1,173 @@
+// Package dhcprelay registers the "showIpDhcpRelayCounters" extension
+// exporting 'show ip dhcp relay counters'
+package dhcprelay
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/arista-northwest/go-passpersist/passpersist"
+	"github.com/arista-northwest/go-passpersist/utils"
+	"github.com/arista-northwest/go-passpersist/utils/arista"
+)
+
+const name = "showIpDhcpRelayCounters"
+
+var fixture = new(string)
+
+type Counters struct {
+	Received  int64 `json:"received"`
+	Forwarded int64 `json:"forwarded"`
+	Dropped   int64 `json:"dropped"`
+}
+
+type InterfaceStats struct {
+	Requests      Counters `json:"requests"`
+	Replies       Counters `json:"replies"`
+	LastResetTime float64  `json:"lastResetTime"`
+}
+
+type GlobalStats struct {
+	AllRequests   Counters `json:"allRequests"`
+	AllResponses  Counters `json:"allResponses"`
+	LastResetTime float64  `json:"lastResetTime"`
+}
+
+type Data struct {
+	GlobalCounters    GlobalStats               `json:"globalCounters"`
+	InterfaceCounters map[string]InterfaceStats `json:"interfaceCounters"`
+}
+
+var (
+	// keeps exported counters monotonic across clears and restarts
+	counters *passpersist.CounterTracker
+	rates    *passpersist.RateTracker
+	// rows tracked after the last refresh, forgotten once they disappear
+	rows map[string]bool
+)
+
+// globalNames names the global scalars at .1.1 to .1.8
+var globalNames = []string{
+	"dhcpRelayRequestsReceived",
+	"dhcpRelayRequestsForwarded",
+	"dhcpRelayRequestsDropped",
+	"dhcpRelayCounterDiscontinuityTime",
+	"dhcpRelayRequestRate",
+	"dhcpRelayRequestRate1m",
+	"dhcpRelayRequestRate5m",
+	"dhcpRelayRequestRate15m",
+}
+
+func init() {
+	passpersist.Register(name, collect,
+		passpersist.WithExtensionRefresh(time.Second*300),
+		passpersist.WithExtensionFlags(func(fs *flag.FlagSet) {
+			fs.StringVar(fixture, "fixture", "", "load 'show ip dhcp relay counters' output from a JSON file instead of running it")
+		}),
+		passpersist.WithExtensionSetup(func(pp *passpersist.PassPersist) {
+			counters = passpersist.NewCounterTracker(
+				passpersist.WithCounterState(filepath.Join(os.TempDir(), name+".counters.json")),
+			)
+			rates = passpersist.NewRateTracker()
+			for i, n := range globalNames {
+				passpersist.DefaultMIBResolver.Add("", n, pp.BaseOID().MustAppend([]int{1, i + 1}))
+			}
+		}),
+	)
+}
+
+func collect(pp *passpersist.PassPersist) {
+	slog.Debug("show ip dhcp relay counters...")
+	data := &Data{}
+	if *fixture != "" {
+		utils.MustLoadMockDataFile(data, *fixture)
+	} else if err := arista.EosCommandJsonContext(pp.Context(), "show ip dhcp relay counters", &data); err != nil {
+		pp.Fail(err)
+		return
+	}
+	seen := make(map[string]bool)
+
+	// global counters are scalars, interfaces rows of dhcpRelayIfTable
+	row := "globalCounters"
+	seen[row] = true
+	counters.SetLastReset(row, resetTime(data.GlobalCounters.LastResetTime))
+	received := counters.Counter64(row, "requestsReceived", data.GlobalCounters.AllRequests.Received)
+	pp.AddScalar([]int{1, 1}, &passpersist.Counter64Val{Value: received})
+	pp.AddScalar([]int{1, 2}, &passpersist.Counter64Val{Value: counters.Counter64(row, "requestsForwarded", data.GlobalCounters.AllRequests.Forwarded)})
+	pp.AddScalar([]int{1, 3}, &passpersist.Counter64Val{Value: counters.Counter64(row, "requestsDropped", data.GlobalCounters.AllRequests.Dropped)})
+	pp.AddScalar([]int{1, 4}, &passpersist.TimeTicksVal{Value: counters.DiscontinuityTime(row)})
+	if r := rates.Update(row, received); r.Valid {
+		for i, g := range r.Gauges() {
+			pp.AddScalar([]int{1, 5 + i}, &passpersist.GaugeVal{Value: g})
+		}
+	}
+
+	ifs, err := pp.NewTable("dhcpRelayIfTable", []int{2},
+		passpersist.Column{ID: 1, Type: "STRING", Name: "dhcpRelayIfName"},
+		passpersist.Column{ID: 2, Type: "Counter64", Name: "dhcpRelayIfRequestsReceived"},
+		passpersist.Column{ID: 3, Type: "Counter64", Name: "dhcpRelayIfRequestsForwarded"},
+		passpersist.Column{ID: 4, Type: "Counter64", Name: "dhcpRelayIfRequestsDropped"},
+		passpersist.Column{ID: 5, Type: "Counter64", Name: "dhcpRelayIfRepliesReceived"},
+		passpersist.Column{ID: 6, Type: "Counter64", Name: "dhcpRelayIfRepliesForwarded"},
+		passpersist.Column{ID: 7, Type: "Counter64", Name: "dhcpRelayIfRepliesDropped"},
+		passpersist.Column{ID: 8, Type: "TIMETICKS", Name: "dhcpRelayIfCounterDiscontinuityTime"},
+		passpersist.Column{ID: 9, Type: "GAUGE", Name: "dhcpRelayIfRequestRate"},
+		passpersist.Column{ID: 10, Type: "GAUGE", Name: "dhcpRelayIfRequestRate1m"},
+		passpersist.Column{ID: 11, Type: "GAUGE", Name: "dhcpRelayIfRequestRate5m"},
+		passpersist.Column{ID: 12, Type: "GAUGE", Name: "dhcpRelayIfRequestRate15m"},
+	)
+	if err != nil {
+		slog.Error("failed to create table", slog.Any("error", err))
+		return
+	}
+
+	// sort the names to keep the walk order stable between refreshes
+	ifaces := make([]string, 0, len(data.InterfaceCounters))
+	for iface := range data.InterfaceCounters {
+		ifaces = append(ifaces, iface)
+	}
+	sort.Strings(ifaces)
+
+	for _, iface := range ifaces {
+		stats := data.InterfaceCounters[iface]
+		seen[iface] = true
+		counters.SetLastReset(iface, resetTime(stats.LastResetTime))
+		received := counters.Counter64(iface, "requestsReceived", stats.Requests.Received)
+
+		r := ifs.Row(passpersist.IndexString(iface))
+		r.Set(1, &passpersist.StringVal{Value: iface})
+		r.Set(2, &passpersist.Counter64Val{Value: received})
+		r.Set(3, &passpersist.Counter64Val{Value: counters.Counter64(iface, "requestsForwarded", stats.Requests.Forwarded)})
+		r.Set(4, &passpersist.Counter64Val{Value: counters.Counter64(iface, "requestsDropped", stats.Requests.Dropped)})
+		r.Set(5, &passpersist.Counter64Val{Value: counters.Counter64(iface, "repliesReceived", stats.Replies.Received)})
+		r.Set(6, &passpersist.Counter64Val{Value: counters.Counter64(iface, "repliesForwarded", stats.Replies.Forwarded)})
+		r.Set(7, &passpersist.Counter64Val{Value: counters.Counter64(iface, "repliesDropped", stats.Replies.Dropped)})
+		r.Set(8, &passpersist.TimeTicksVal{Value: counters.DiscontinuityTime(iface)})
+		if rt := rates.Update(iface, received); rt.Valid {
+			for i, g := range rt.Gauges() {
+				r.Set(9+i, &passpersist.GaugeVal{Value: g})
+			}
+		}
+	}
+
+	for row := range rows {
+		if !seen[row] {
+			counters.Forget(row)
+			rates.Forget(row)
+		}
+	}
+	rows = seen
+
+	if err := counters.Save(); err != nil {
+		slog.Warn("failed to save counter state", slog.Any("error", err))
+	}
+}
+
+// resetTime converts an EOS lastResetTime in seconds since the epoch
+func resetTime(sec float64) time.Time {
+	return time.Unix(0, int64(sec*float64(time.Second)))
+}
@@ -0,0 +1,19 @@
+// Package hello registers the "hello" example extension
+package hello
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/arista-northwest/go-passpersist/passpersist"
+)
+
+func init() {
+	passpersist.Register("hello", collect, passpersist.WithExtensionRefresh(time.Second*300))
+}
+
+func collect(pp *passpersist.PassPersist) {
+	slog.Debug("updating...")
+	pp.AddString([]int{0}, "Hello from PassPersist")
+	pp.AddString([]int{1}, "You found a secret message!")
+}
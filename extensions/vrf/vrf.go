@@ -0,0 +1,111 @@
+// Package vrf registers the "vrf" extension exporting 'show vrf'
+package vrf
+
+import (
+	"flag"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/arista-northwest/go-passpersist/passpersist"
+	"github.com/arista-northwest/go-passpersist/utils"
+	"github.com/arista-northwest/go-passpersist/utils/arista"
+)
+
+var fixture = new(string)
+
+// Définition de la structure pour les protocoles (protocols)
+type Protocol struct {
+	RoutingState  string `json:"routingState"`
+	ProtocolState string `json:"protocolState"`
+	Supported     bool   `json:"supported"`
+}
+
+// Définition de la structure pour les VRF (Virtual Routing and Forwarding)
+type Vrf struct {
+	RouteDistinguisher string              `json:"routeDistinguisher"`
+	VrfState           string              `json:"vrfState"`
+	InterfacesV6       []string            `json:"interfacesV6"`
+	InterfacesV4       []string            `json:"interfacesV4"`
+	Interfaces         []string            `json:"interfaces"`
+	Protocols          map[string]Protocol `json:"protocols"`
+}
+
+// Définition de la structure principale (VRFs map)
+type Vrfs struct {
+	Vrfs map[string]Vrf `json:"vrfs"`
+}
+
+func init() {
+	passpersist.Register("vrf", collect,
+		passpersist.WithExtensionRefresh(time.Second*300),
+		passpersist.WithExtensionFlags(func(fs *flag.FlagSet) {
+			fs.StringVar(fixture, "fixture", "", "load 'show vrf' output from a JSON file instead of running it")
+		}),
+		passpersist.WithExtensionSetup(func(pp *passpersist.PassPersist) {
+			passpersist.DefaultMIBResolver.Add("", "vrfCount", pp.BaseOID().MustAppend([]int{1}))
+			pp.OnChange(func(d passpersist.Diff) {
+				for _, c := range d.Changed {
+					slog.Info("vrf value changed", "oid", c.New.OID.String(), "was", c.Old.Value.String(), "now", c.New.Value.String())
+				}
+			})
+		}),
+	)
+}
+
+func collect(pp *passpersist.PassPersist) {
+	slog.Debug("show vrf...")
+	data := &Vrfs{}
+	if *fixture != "" {
+		utils.MustLoadMockDataFile(data, *fixture)
+	} else if err := arista.EosCommandJsonContext(pp.Context(), "show vrf", &data); err != nil {
+		pp.Fail(err)
+		return
+	}
+	// sort the names to keep the walk order stable between refreshes
+	names := make([]string, 0, len(data.Vrfs))
+	for vrfName := range data.Vrfs {
+		names = append(names, vrfName)
+	}
+	sort.Strings(names)
+
+	pp.AddScalar([]int{1}, &passpersist.GaugeVal{Value: uint32(len(names))})
+
+	vrfs, err := pp.NewTable("vrfTable", []int{2},
+		passpersist.Column{ID: 1, Type: "STRING", Name: "vrfName"},
+		passpersist.Column{ID: 2, Type: "STRING", Name: "vrfRouteDistinguisher"},
+		passpersist.Column{ID: 3, Name: "vrfState", Enum: passpersist.ProtocolState},
+	)
+	if err != nil {
+		slog.Error("failed to create table", slog.Any("error", err))
+		return
+	}
+	protocols, err := pp.NewTable("vrfProtocolTable", []int{3},
+		passpersist.Column{ID: 1, Type: "STRING", Name: "vrfProtocolName"},
+		passpersist.Column{ID: 2, Name: "vrfProtocolRoutingState", Enum: passpersist.ProtocolState},
+		passpersist.Column{ID: 3, Name: "vrfProtocolState", Enum: passpersist.ProtocolState},
+		passpersist.Column{ID: 4, Name: "vrfProtocolSupported", Enum: passpersist.TruthValue},
+	)
+	if err != nil {
+		slog.Error("failed to create table", slog.Any("error", err))
+		return
+	}
+	protocols.SetIndex("vrfName", "vrfProtocolName")
+
+	for _, vrfName := range names {
+		vrfData := data.Vrfs[vrfName]
+		row := vrfs.Row(passpersist.IndexString(vrfName))
+		row.Set(1, &passpersist.StringVal{Value: vrfName})
+		row.Set(2, &passpersist.StringVal{Value: vrfData.RouteDistinguisher})
+		row.SetEnum(3, vrfData.VrfState)
+
+		for protoName, protoData := range vrfData.Protocols {
+			row := protocols.Row(passpersist.IndexString(vrfName), passpersist.IndexString(protoName))
+			row.Set(1, &passpersist.StringVal{Value: protoName})
+			row.SetEnum(2, protoData.RoutingState)
+			row.SetEnum(3, protoData.ProtocolState)
+			row.SetEnum(4, strconv.FormatBool(protoData.Supported))
+		}
+	}
+}
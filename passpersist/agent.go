@@ -0,0 +1,618 @@
+package passpersist
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+const (
+	snmpV2c = 1
+	snmpV3  = 3
+
+	usmSecurityModel = 3
+
+	// largest UDP payload over IPv4
+	agentMaxMessageSize = 65507
+
+	// RFC 3414 Section 3.2 (7)
+	usmTimeWindow = 150
+)
+
+// error-status values from RFC 3416
+const (
+	snmpTooBig      = 1
+	snmpNotWritable = 17
+)
+
+var (
+	usmStatsUnsupportedSecLevels = MustNewOID("1.3.6.1.6.3.15.1.1.1.0")
+	usmStatsNotInTimeWindows     = MustNewOID("1.3.6.1.6.3.15.1.1.2.0")
+	usmStatsUnknownUserNames     = MustNewOID("1.3.6.1.6.3.15.1.1.3.0")
+	usmStatsUnknownEngineIDs     = MustNewOID("1.3.6.1.6.3.15.1.1.4.0")
+	usmStatsWrongDigests         = MustNewOID("1.3.6.1.6.3.15.1.1.5.0")
+	usmStatsDecryptionErrors     = MustNewOID("1.3.6.1.6.3.15.1.1.6.0")
+)
+
+type AgentOption func(*Agent)
+
+// WithCommunity sets the SNMPv2c community, an empty string disables v2c
+func WithCommunity(c string) AgentOption {
+	return func(a *Agent) {
+		a.community = c
+	}
+}
+
+// WithUSMUser adds an SNMPv3 user
+func WithUSMUser(u USMUser) AgentOption {
+	return func(a *Agent) {
+		a.users[u.Name] = &u
+	}
+}
+
+func WithEngineID(id []byte) AgentOption {
+	return func(a *Agent) {
+		a.engineID = id
+	}
+}
+
+func WithEngineBoots(b int32) AgentOption {
+	return func(a *Agent) {
+		a.boots = b
+	}
+}
+
+// Agent serves the PassPersist cache directly over UDP without snmpd.
+// It answers SNMPv2c and SNMPv3 (USM) Get, GetNext and GetBulk requests and
+// is meant for off-box development and testing.
+type Agent struct {
+	pp        *PassPersist
+	community string
+	engineID  []byte
+	boots     int32
+	start     time.Time
+	users     map[string]*USMUser
+	salt      uint64
+	stats     map[string]uint32
+}
+
+func NewAgent(p *PassPersist, opts ...AgentOption) *Agent {
+	a := &Agent{
+		pp:        p,
+		community: "public",
+		engineID:  newEngineID(),
+		boots:     1,
+		start:     time.Now(),
+		users:     make(map[string]*USMUser),
+		stats:     make(map[string]uint32),
+	}
+
+	for _, fn := range opts {
+		fn(a)
+	}
+
+	for name, u := range a.users {
+		if err := u.validate(); err != nil {
+			slog.Error("ignoring invalid usm user", "user", name, slog.Any("error", err))
+			delete(a.users, name)
+			continue
+		}
+		u.localize(a.engineID)
+	}
+
+	var s [8]byte
+	_, _ = rand.Read(s[:])
+	a.salt = binary.BigEndian.Uint64(s[:])
+
+	return a
+}
+
+// newEngineID builds a random engine ID in the RFC 3411 octets format under
+// the Arista enterprise number
+func newEngineID() []byte {
+	id := []byte{0x80, 0x00, 0x75, 0x71, 0x05, 0, 0, 0, 0, 0, 0, 0, 0}
+	_, _ = rand.Read(id[5:])
+	return id
+}
+
+func (a *Agent) EngineID() []byte {
+	return a.engineID
+}
+
+func (a *Agent) engineTime() int32 {
+	return int32(time.Since(a.start) / time.Second)
+}
+
+func (a *Agent) ListenAndServe(ctx context.Context, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("agent listening", "addr", conn.LocalAddr().String())
+	return a.Serve(ctx, conn)
+}
+
+// Serve answers requests on conn until the context is cancelled
+func (a *Agent) Serve(ctx context.Context, conn net.PacketConn) error {
+	defer conn.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		resp, err := a.handle(buf[:n])
+		if err != nil {
+			slog.Debug("dropping request", "from", addr.String(), slog.Any("error", err))
+			continue
+		}
+		if resp == nil {
+			continue
+		}
+
+		if _, err := conn.WriteTo(resp, addr); err != nil {
+			slog.Warn("failed to send response", "to", addr.String(), slog.Any("error", err))
+		}
+	}
+}
+
+func (a *Agent) handle(pkt []byte) ([]byte, error) {
+	body, _, err := berExpect(pkt, berSequence)
+	if err != nil {
+		return nil, err
+	}
+	version, body, err := berReadInt(body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch version {
+	case snmpV2c:
+		return a.handleCommunity(body)
+	case snmpV3:
+		return a.handleUSM(pkt, body)
+	}
+	return nil, fmt.Errorf("unsupported snmp version %d", version)
+}
+
+func (a *Agent) handleCommunity(body []byte) ([]byte, error) {
+	community, body, err := berReadOctets(body)
+	if err != nil {
+		return nil, err
+	}
+	if a.community == "" || string(community) != a.community {
+		return nil, errors.New("unknown community")
+	}
+
+	req, err := unmarshalPDU(body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := a.process(req, agentMaxMessageSize)
+	if resp == nil {
+		return nil, fmt.Errorf("unsupported pdu type 0x%02x", req.Type)
+	}
+
+	p, err := resp.marshal()
+	if err != nil {
+		return nil, err
+	}
+	return berTLV(berSequence, berInt(berInteger, snmpV2c), berOctets(community), p), nil
+}
+
+// process answers a request PDU, maxSize bounds the encoded varbinds
+func (a *Agent) process(req *snmpPDU, maxSize int) *snmpPDU {
+	resp := &snmpPDU{
+		Type:      pduResponse,
+		RequestID: req.RequestID,
+	}
+
+	switch req.Type {
+	case pduGetRequest:
+		for _, vb := range req.VarBinds {
+			resp.VarBinds = append(resp.VarBinds, a.get(vb.OID))
+		}
+	case pduGetNextRequest:
+		for _, vb := range req.VarBinds {
+			resp.VarBinds = append(resp.VarBinds, a.getNext(vb.OID))
+		}
+	case pduGetBulkRequest:
+		resp.VarBinds = a.getBulk(req, maxSize)
+	case pduSetRequest:
+		resp.ErrorStatus = snmpNotWritable
+		resp.ErrorIndex = 1
+		resp.VarBinds = req.VarBinds
+	default:
+		return nil
+	}
+
+	if req.Type != pduGetBulkRequest && varBindsSize(resp.VarBinds) > maxSize {
+		resp.ErrorStatus = snmpTooBig
+		resp.VarBinds = req.VarBinds
+	}
+
+	return resp
+}
+
+func (a *Agent) get(oid OID) snmpVarBind {
+	if !oid.StartsWith(a.pp.baseOID) {
+		return snmpVarBind{OID: oid, Value: []byte{berNoSuchObject, 0}}
+	}
+	if v := a.pp.get(oid); v != nil {
+		if vb, err := v.snmpVarBind(); err == nil {
+			return vb
+		}
+	}
+	return snmpVarBind{OID: oid, Value: []byte{berNoSuchInstance, 0}}
+}
+
+func (a *Agent) getNext(oid OID) snmpVarBind {
+	if oid.Compare(a.pp.baseOID) < 0 {
+		oid = a.pp.baseOID
+	}
+	if oid.StartsWith(a.pp.baseOID) {
+		if v := a.pp.getNext(oid); v != nil {
+			if vb, err := v.snmpVarBind(); err == nil {
+				return vb
+			}
+		}
+	}
+	return snmpVarBind{OID: oid, Value: []byte{berEndOfMibView, 0}}
+}
+
+// RFC 3416 Section 4.2.3
+func (a *Agent) getBulk(req *snmpPDU, maxSize int) []snmpVarBind {
+	nonRepeaters := req.ErrorStatus
+	if nonRepeaters < 0 {
+		nonRepeaters = 0
+	}
+	if nonRepeaters > len(req.VarBinds) {
+		nonRepeaters = len(req.VarBinds)
+	}
+	maxRepetitions := req.ErrorIndex
+	if maxRepetitions < 0 {
+		maxRepetitions = 0
+	}
+
+	var out []snmpVarBind
+	size := 0
+	add := func(vb snmpVarBind) bool {
+		n := varBindsSize([]snmpVarBind{vb})
+		if size+n > maxSize {
+			return false
+		}
+		size += n
+		out = append(out, vb)
+		return true
+	}
+
+	for _, vb := range req.VarBinds[:nonRepeaters] {
+		if !add(a.getNext(vb.OID)) {
+			return out
+		}
+	}
+
+	cursors := make([]OID, 0, len(req.VarBinds)-nonRepeaters)
+	for _, vb := range req.VarBinds[nonRepeaters:] {
+		cursors = append(cursors, vb.OID)
+	}
+
+	for r := 0; r < maxRepetitions; r++ {
+		done := true
+		for i, o := range cursors {
+			vb := a.getNext(o)
+			if vb.Value[0] != berEndOfMibView {
+				done = false
+			}
+			cursors[i] = vb.OID
+			if !add(vb) {
+				return out
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	return out
+}
+
+func varBindsSize(vbs []snmpVarBind) int {
+	n := 0
+	for _, vb := range vbs {
+		b, err := vb.marshal()
+		if err == nil {
+			n += len(b)
+		}
+	}
+	return n
+}
+
+// v3Message is a decoded SNMPv3 message using the user-based security model
+type v3Message struct {
+	MsgID      int32
+	MaxSize    int
+	Flags      byte
+	EngineID   []byte
+	Boots      int32
+	Time       int32
+	UserName   string
+	PrivParams []byte
+	// Data is the scoped PDU, or the encrypted scoped PDU when
+	// the priv flag is set
+	Data []byte
+
+	// position of msgAuthenticationParameters in the raw message
+	authOffset int
+}
+
+func unmarshalV3(pkt []byte, body []byte) (*v3Message, error) {
+	m := &v3Message{}
+
+	global, body, err := berExpect(body, berSequence)
+	if err != nil {
+		return nil, err
+	}
+	var v int64
+	if v, global, err = berReadInt(global); err != nil {
+		return nil, err
+	}
+	m.MsgID = int32(v)
+	if v, global, err = berReadInt(global); err != nil {
+		return nil, err
+	}
+	m.MaxSize = int(v)
+	flags, global, err := berReadOctets(global)
+	if err != nil {
+		return nil, err
+	}
+	if len(flags) != 1 {
+		return nil, errors.New("invalid msgFlags")
+	}
+	m.Flags = flags[0]
+	if v, _, err = berReadInt(global); err != nil {
+		return nil, err
+	}
+	if v != usmSecurityModel {
+		return nil, fmt.Errorf("unsupported security model %d", v)
+	}
+
+	params, body, err := berReadOctets(body)
+	if err != nil {
+		return nil, err
+	}
+	usm, _, err := berExpect(params, berSequence)
+	if err != nil {
+		return nil, err
+	}
+	if m.EngineID, usm, err = berReadOctets(usm); err != nil {
+		return nil, err
+	}
+	if v, usm, err = berReadInt(usm); err != nil {
+		return nil, err
+	}
+	m.Boots = int32(v)
+	if v, usm, err = berReadInt(usm); err != nil {
+		return nil, err
+	}
+	m.Time = int32(v)
+	user, usm, err := berReadOctets(usm)
+	if err != nil {
+		return nil, err
+	}
+	m.UserName = string(user)
+	auth, usm, err := berReadOctets(usm)
+	if err != nil {
+		return nil, err
+	}
+	// auth shares the backing array with pkt
+	m.authOffset = cap(pkt) - cap(auth)
+	if m.PrivParams, _, err = berReadOctets(usm); err != nil {
+		return nil, err
+	}
+
+	if m.Flags&usmFlagPriv != 0 {
+		if m.Data, _, err = berReadOctets(body); err != nil {
+			return nil, err
+		}
+	} else {
+		m.Data = body
+	}
+
+	return m, nil
+}
+
+func unmarshalScopedPDU(b []byte) (contextName []byte, pdu *snmpPDU, err error) {
+	s, _, err := berExpect(b, berSequence)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, s, err = berReadOctets(s); err != nil {
+		return nil, nil, err
+	}
+	if contextName, s, err = berReadOctets(s); err != nil {
+		return nil, nil, err
+	}
+	pdu, err = unmarshalPDU(s)
+	return contextName, pdu, err
+}
+
+func (a *Agent) handleUSM(pkt []byte, body []byte) ([]byte, error) {
+	m, err := unmarshalV3(pkt, body)
+	if err != nil {
+		return nil, err
+	}
+
+	level := m.Flags & (usmFlagAuth | usmFlagPriv)
+
+	if !bytes.Equal(m.EngineID, a.engineID) {
+		return a.report(m, nil, usmStatsUnknownEngineIDs)
+	}
+
+	u, ok := a.users[m.UserName]
+	if !ok {
+		return a.report(m, nil, usmStatsUnknownUserNames)
+	}
+
+	if level != u.flags() {
+		return a.report(m, nil, usmStatsUnsupportedSecLevels)
+	}
+
+	if level&usmFlagAuth != 0 {
+		if !u.verify(pkt, m.authOffset) {
+			return a.report(m, nil, usmStatsWrongDigests)
+		}
+
+		t := m.Time - a.engineTime()
+		if t < 0 {
+			t = -t
+		}
+		if m.Boots != a.boots || t > usmTimeWindow {
+			return a.report(m, u, usmStatsNotInTimeWindows)
+		}
+	}
+
+	data := m.Data
+	if level&usmFlagPriv != 0 {
+		if data, err = u.decrypt(m.Data, m.PrivParams, m.Boots, m.Time); err != nil {
+			return a.report(m, u, usmStatsDecryptionErrors)
+		}
+	}
+
+	contextName, req, err := unmarshalScopedPDU(data)
+	if err != nil {
+		if level&usmFlagPriv != 0 {
+			return a.report(m, u, usmStatsDecryptionErrors)
+		}
+		return nil, err
+	}
+
+	maxSize := m.MaxSize
+	if maxSize <= 0 || maxSize > agentMaxMessageSize {
+		maxSize = agentMaxMessageSize
+	}
+
+	// leave room for the message header and security parameters
+	resp := a.process(req, maxSize-256)
+	if resp == nil {
+		return nil, fmt.Errorf("unsupported pdu type 0x%02x", req.Type)
+	}
+
+	return a.marshalV3(m.MsgID, level, m.UserName, u, contextName, resp)
+}
+
+// report answers a failed request with the incremented usmStats counter,
+// the report is authenticated only when u is given
+func (a *Agent) report(m *v3Message, u *USMUser, counter OID) ([]byte, error) {
+	a.stats[counter.String()]++
+
+	if m.Flags&usmFlagReportable == 0 {
+		return nil, fmt.Errorf("dropping unreportable request: %s", counter)
+	}
+
+	var requestID int32
+	var contextName []byte
+	if m.Flags&usmFlagPriv == 0 {
+		if c, req, err := unmarshalScopedPDU(m.Data); err == nil {
+			requestID = req.RequestID
+			contextName = c
+		}
+	}
+
+	var level byte
+	if u != nil {
+		level = u.flags() & usmFlagAuth
+	}
+
+	r := &snmpPDU{
+		Type:      pduReport,
+		RequestID: requestID,
+		VarBinds: []snmpVarBind{
+			{OID: counter, Value: berUint(berCounter32, uint64(a.stats[counter.String()]))},
+		},
+	}
+
+	return a.marshalV3(m.MsgID, level, m.UserName, u, contextName, r)
+}
+
+func (a *Agent) marshalV3(msgID int32, level byte, userName string, u *USMUser, contextName []byte, pdu *snmpPDU) ([]byte, error) {
+	p, err := pdu.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	boots := a.boots
+	engineTime := a.engineTime()
+
+	data := berTLV(berSequence, berOctets(a.engineID), berOctets(contextName), p)
+	var privParams []byte
+	if level&usmFlagPriv != 0 {
+		a.salt++
+		enc, params, err := u.encrypt(data, boots, engineTime, a.salt)
+		if err != nil {
+			return nil, err
+		}
+		data = berOctets(enc)
+		privParams = params
+	}
+
+	var digest []byte
+	if level&usmFlagAuth != 0 {
+		digest = make([]byte, u.AuthProtocol.digestLen())
+	}
+
+	global := berTLV(berSequence,
+		berInt(berInteger, int64(msgID)),
+		berInt(berInteger, agentMaxMessageSize),
+		berOctets([]byte{level}),
+		berInt(berInteger, usmSecurityModel),
+	)
+
+	pre := bytes.Join([][]byte{
+		berOctets(a.engineID),
+		berInt(berInteger, int64(boots)),
+		berInt(berInteger, int64(engineTime)),
+		berOctets([]byte(userName)),
+	}, nil)
+	auth := berOctets(digest)
+	usmBody := bytes.Join([][]byte{pre, auth, berOctets(privParams)}, nil)
+	usm := berTLV(berSequence, usmBody)
+	params := berOctets(usm)
+	head := bytes.Join([][]byte{berInt(berInteger, snmpV3), global}, nil)
+	body := bytes.Join([][]byte{head, params, data}, nil)
+	msg := berTLV(berSequence, body)
+
+	if level&usmFlagAuth != 0 {
+		off := len(msg) - len(body) +
+			len(head) +
+			len(params) - len(usm) +
+			len(usm) - len(usmBody) +
+			len(pre) +
+			len(auth) - len(digest)
+		u.authenticate(msg, off)
+	}
+
+	return msg, nil
+}
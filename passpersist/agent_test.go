@@ -0,0 +1,218 @@
+package passpersist
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func newTestAgentPassPersist() *PassPersist {
+	p := NewPassPersist(WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")))
+	p.AddString([]int{1}, "one")
+	p.AddInt([]int{2}, -2)
+	p.AddCounter64([]int{3}, 1<<40)
+	p.cache.Commit()
+	return p
+}
+
+func v2cRequest(t *testing.T, typ byte, a, b int, oids ...string) []byte {
+	req := &snmpPDU{Type: typ, RequestID: 42, ErrorStatus: a, ErrorIndex: b}
+	for _, s := range oids {
+		req.VarBinds = append(req.VarBinds, snmpVarBind{OID: MustNewOID(s), Value: berNullValue()})
+	}
+	p, err := req.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return berTLV(berSequence, berInt(berInteger, snmpV2c), berOctets([]byte("public")), p)
+}
+
+func v2cResponse(t *testing.T, b []byte) *snmpPDU {
+	body, _, err := berExpect(b, berSequence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, body, err = berReadInt(body); err != nil {
+		t.Fatal(err)
+	}
+	if _, body, err = berReadOctets(body); err != nil {
+		t.Fatal(err)
+	}
+	p, err := unmarshalPDU(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAgentCommunity(t *testing.T) {
+	a := NewAgent(newTestAgentPassPersist())
+
+	tests := []struct {
+		name string
+		req  []byte
+		want []string
+		tags []byte
+	}{
+		{
+			name: "get",
+			req:  v2cRequest(t, pduGetRequest, 0, 0, "1.3.6.1.4.1.8072.2.255.1", "1.3.6.1.4.1.8072.2.255.9", "1.3.6.1.2"),
+			want: []string{"1.3.6.1.4.1.8072.2.255.1", "1.3.6.1.4.1.8072.2.255.9", "1.3.6.1.2"},
+			tags: []byte{berOctetString, berNoSuchInstance, berNoSuchObject},
+		},
+		{
+			name: "getnext",
+			req:  v2cRequest(t, pduGetNextRequest, 0, 0, "1.3.6.1", "1.3.6.1.4.1.8072.2.255.3"),
+			want: []string{"1.3.6.1.4.1.8072.2.255.1", "1.3.6.1.4.1.8072.2.255.3"},
+			tags: []byte{berOctetString, berEndOfMibView},
+		},
+		{
+			name: "getbulk",
+			req:  v2cRequest(t, pduGetBulkRequest, 0, 10, "1.3.6.1.4.1.8072.2.255"),
+			want: []string{"1.3.6.1.4.1.8072.2.255.1", "1.3.6.1.4.1.8072.2.255.2", "1.3.6.1.4.1.8072.2.255.3", "1.3.6.1.4.1.8072.2.255.3"},
+			tags: []byte{berOctetString, berInteger, berCounter64, berEndOfMibView},
+		},
+	}
+
+	for _, tst := range tests {
+		b, err := a.handle(tst.req)
+		if err != nil {
+			t.Fatalf("%s: %s", tst.name, err)
+		}
+		resp := v2cResponse(t, b)
+		if resp.RequestID != 42 {
+			t.Errorf("%s: wrong request id %d", tst.name, resp.RequestID)
+		}
+		if len(resp.VarBinds) != len(tst.want) {
+			t.Fatalf("%s: expected %d varbinds, got %d", tst.name, len(tst.want), len(resp.VarBinds))
+		}
+		for i, vb := range resp.VarBinds {
+			if vb.OID.String() != tst.want[i] || vb.Value[0] != tst.tags[i] {
+				t.Errorf("%s: varbind %d is %s/0x%02x, wanted %s/0x%02x", tst.name, i, vb.OID, vb.Value[0], tst.want[i], tst.tags[i])
+			}
+		}
+	}
+
+	if _, err := NewAgent(newTestAgentPassPersist(), WithCommunity("secret")).handle(tests[0].req); err == nil {
+		t.Error("expected wrong community to be dropped")
+	}
+}
+
+func TestLocalizeKey(t *testing.T) {
+	// RFC 3414 Appendix A.3
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	tests := []struct {
+		proto AuthProtocol
+		want  string
+	}{
+		{AuthMD5, "526f5eed9fcce26f8964c2930787d82b"},
+		{AuthSHA, "6695febc9288e36282235fc7151f128497b38f3f"},
+	}
+
+	for _, tst := range tests {
+		u := USMUser{Name: "test", AuthProtocol: tst.proto, AuthPassword: "maplesyrup"}
+		u.localize(engineID)
+		if got := hex.EncodeToString(u.authKey); got != tst.want {
+			t.Errorf("%s: got key %s, wanted %s", tst.proto, got, tst.want)
+		}
+	}
+}
+
+func TestAgentUSM(t *testing.T) {
+	for _, priv := range []PrivProtocol{NoPriv, PrivDES, PrivAES} {
+		user := USMUser{Name: "admin", AuthProtocol: AuthSHA, AuthPassword: "authpass123"}
+		if priv != NoPriv {
+			user.PrivProtocol = priv
+			user.PrivPassword = "privpass123"
+		}
+		a := NewAgent(newTestAgentPassPersist(), WithUSMUser(user))
+
+		// the client shares the engine with the agent after discovery
+		client := &Agent{engineID: a.engineID, boots: a.boots, start: a.start}
+		u := user
+		u.localize(a.engineID)
+		level := u.flags() | usmFlagReportable
+
+		req := &snmpPDU{
+			Type:      pduGetRequest,
+			RequestID: 7,
+			VarBinds:  []snmpVarBind{{OID: MustNewOID("1.3.6.1.4.1.8072.2.255.1"), Value: berNullValue()}},
+		}
+		msg, err := client.marshalV3(1, level, u.Name, &u, nil, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := a.handle(msg)
+		if err != nil {
+			t.Fatalf("%s: %s", priv, err)
+		}
+
+		body, _, _ := berExpect(b, berSequence)
+		_, body, _ = berReadInt(body)
+		m, err := unmarshalV3(b, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !u.verify(b, m.authOffset) {
+			t.Errorf("%s: response failed authentication", priv)
+		}
+		data := m.Data
+		if priv != NoPriv {
+			if data, err = u.decrypt(m.Data, m.PrivParams, m.Boots, m.Time); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, resp, err := unmarshalScopedPDU(data)
+		if err != nil {
+			t.Fatalf("%s: %s", priv, err)
+		}
+		if resp.Type != pduResponse || resp.RequestID != 7 || resp.VarBinds[0].Value[0] != berOctetString {
+			t.Errorf("%s: unexpected response %+v", priv, resp)
+		}
+
+		// a tampered message must be rejected with a report
+		msg[len(msg)-1] ^= 0xff
+		b, err = a.handle(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _, _ = berExpect(b, berSequence)
+		_, body, _ = berReadInt(body)
+		m, _ = unmarshalV3(b, body)
+		if _, r, err := unmarshalScopedPDU(m.Data); err != nil || r.Type != pduReport || !r.VarBinds[0].OID.Equal(usmStatsWrongDigests) {
+			t.Errorf("%s: expected a wrong digest report", priv)
+		}
+	}
+}
+
+func TestAgentDiscovery(t *testing.T) {
+	a := NewAgent(newTestAgentPassPersist())
+	client := &Agent{engineID: []byte{}, boots: 0}
+
+	req := &snmpPDU{Type: pduGetRequest, RequestID: 3}
+	msg, err := client.marshalV3(1, usmFlagReportable, "", nil, nil, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := a.handle(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _, _ := berExpect(b, berSequence)
+	_, body, _ = berReadInt(body)
+	m, err := unmarshalV3(b, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(m.EngineID) != hex.EncodeToString(a.EngineID()) {
+		t.Errorf("report does not carry the engine id")
+	}
+	_, r, err := unmarshalScopedPDU(m.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Type != pduReport || r.RequestID != 3 || !r.VarBinds[0].OID.Equal(usmStatsUnknownEngineIDs) {
+		t.Errorf("unexpected report %+v", r)
+	}
+}
@@ -0,0 +1,296 @@
+package passpersist
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BER tags for the subset of SNMP used by the embedded agent (RFC 3416)
+const (
+	berInteger        byte = 0x02
+	berOctetString    byte = 0x04
+	berNull           byte = 0x05
+	berObjectID       byte = 0x06
+	berSequence       byte = 0x30
+	berIPAddress      byte = 0x40
+	berCounter32      byte = 0x41
+	berGauge32        byte = 0x42
+	berTimeTicks      byte = 0x43
+	berCounter64      byte = 0x46
+	berNoSuchObject   byte = 0x80
+	berNoSuchInstance byte = 0x81
+	berEndOfMibView   byte = 0x82
+
+	pduGetRequest     byte = 0xa0
+	pduGetNextRequest byte = 0xa1
+	pduResponse       byte = 0xa2
+	pduSetRequest     byte = 0xa3
+	pduGetBulkRequest byte = 0xa5
+	pduInformRequest  byte = 0xa6
+	pduSNMPv2Trap     byte = 0xa7
+	pduReport         byte = 0xa8
+)
+
+var errBERTruncated = errors.New("truncated BER data")
+
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func berTLV(tag byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	l := berLength(n)
+	b := make([]byte, 0, 1+len(l)+n)
+	b = append(b, tag)
+	b = append(b, l...)
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func berInt(tag byte, v int64) []byte {
+	b := []byte{byte(v)}
+	for v > 0x7f || v < -0x80 {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	return berTLV(tag, b)
+}
+
+func berUint(tag byte, v uint64) []byte {
+	b := []byte{byte(v)}
+	for v > 0xff {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return berTLV(tag, b)
+}
+
+func berOctets(b []byte) []byte {
+	return berTLV(berOctetString, b)
+}
+
+func berNullValue() []byte {
+	return []byte{berNull, 0}
+}
+
+// berRead splits the first TLV off b
+func berRead(b []byte) (tag byte, content []byte, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errBERTruncated
+	}
+	tag = b[0]
+	l := int(b[1])
+	pos := 2
+	if l&0x80 != 0 {
+		n := l & 0x7f
+		if n == 0 || n > 4 || len(b) < pos+n {
+			return 0, nil, nil, fmt.Errorf("invalid BER length for tag 0x%02x", tag)
+		}
+		l = 0
+		for _, c := range b[pos : pos+n] {
+			l = l<<8 | int(c)
+		}
+		pos += n
+	}
+	if l < 0 || len(b) < pos+l {
+		return 0, nil, nil, errBERTruncated
+	}
+	return tag, b[pos : pos+l], b[pos+l:], nil
+}
+
+// berExpect reads the next TLV and fails if its tag does not match
+func berExpect(b []byte, tag byte) (content []byte, rest []byte, err error) {
+	t, c, r, err := berRead(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t != tag {
+		return nil, nil, fmt.Errorf("unexpected BER tag 0x%02x, wanted 0x%02x", t, tag)
+	}
+	return c, r, nil
+}
+
+func berParseInt(c []byte) (int64, error) {
+	if len(c) == 0 || len(c) > 8 {
+		return 0, fmt.Errorf("invalid BER integer length %d", len(c))
+	}
+	v := int64(int8(c[0]))
+	for _, b := range c[1:] {
+		v = v<<8 | int64(b)
+	}
+	return v, nil
+}
+
+func berReadInt(b []byte) (int64, []byte, error) {
+	c, rest, err := berExpect(b, berInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	v, err := berParseInt(c)
+	return v, rest, err
+}
+
+func berReadOctets(b []byte) ([]byte, []byte, error) {
+	return berExpect(b, berOctetString)
+}
+
+func berReadOID(b []byte) (OID, []byte, error) {
+	t, c, rest, err := berRead(b)
+	if err != nil {
+		return OID{}, nil, err
+	}
+	if t != berObjectID {
+		return OID{}, nil, fmt.Errorf("unexpected BER tag 0x%02x, wanted object identifier", t)
+	}
+	var id asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(berTLV(berObjectID, c), &id); err != nil {
+		return OID{}, nil, err
+	}
+	o := make([]uint32, len(id))
+	for i, s := range id {
+		o[i] = uint32(s)
+	}
+	return OID{o}, rest, nil
+}
+
+// marshalBER encodes the value with its SNMP application tag
+func (v *typedValue) marshalBER() ([]byte, error) {
+	switch x := v.GetValue().(type) {
+	case *StringVal:
+		return berOctets([]byte(x.Value)), nil
+	case *IntVal:
+		return berInt(berInteger, int64(x.Value)), nil
+	case *Counter32Val:
+		return berUint(berCounter32, uint64(x.Value)), nil
+	case *Counter64Val:
+		return berUint(berCounter64, x.Value), nil
+	case *GaugeVal:
+		return berUint(berGauge32, uint64(x.Value)), nil
+	case *OctetStringVal:
+		return berOctets(x.Value), nil
+	case *IPAddrVal:
+		a := x.Value.Unmap()
+		if !a.Is4() {
+			return nil, fmt.Errorf("not an IPv4 address: %s", x.Value)
+		}
+		b := a.As4()
+		return berTLV(berIPAddress, b[:]), nil
+	case *IPV6AddrVal:
+		// pass_persist exports these as STRING, keep the agent consistent
+		return berOctets([]byte(x.Value.String())), nil
+	case *OIDVal:
+		return x.Value.Marshal()
+	case *TimeTicksVal:
+		return berUint(berTimeTicks, uint64(x.Value/(10*time.Millisecond))), nil
+	}
+	return nil, fmt.Errorf("unsupported value type: %T", v.GetValue())
+}
+
+// snmpVarBind is a name and an already encoded value
+type snmpVarBind struct {
+	OID   OID
+	Value []byte
+}
+
+func (vb snmpVarBind) marshal() ([]byte, error) {
+	o, err := vb.OID.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return berTLV(berSequence, o, vb.Value), nil
+}
+
+func (r *VarBind) snmpVarBind() (snmpVarBind, error) {
+	b, err := r.Value.marshalBER()
+	if err != nil {
+		return snmpVarBind{}, err
+	}
+	return snmpVarBind{OID: r.OID, Value: b}, nil
+}
+
+type snmpPDU struct {
+	Type      byte
+	RequestID int32
+	// ErrorStatus and ErrorIndex hold non-repeaters and max-repetitions in
+	// a GetBulkRequest
+	ErrorStatus int
+	ErrorIndex  int
+	VarBinds    []snmpVarBind
+}
+
+func (p *snmpPDU) marshal() ([]byte, error) {
+	vbs := make([][]byte, 0, len(p.VarBinds))
+	for _, vb := range p.VarBinds {
+		b, err := vb.marshal()
+		if err != nil {
+			return nil, err
+		}
+		vbs = append(vbs, b)
+	}
+
+	return berTLV(p.Type,
+		berInt(berInteger, int64(p.RequestID)),
+		berInt(berInteger, int64(p.ErrorStatus)),
+		berInt(berInteger, int64(p.ErrorIndex)),
+		berTLV(berSequence, vbs...),
+	), nil
+}
+
+func unmarshalPDU(b []byte) (*snmpPDU, error) {
+	t, c, _, err := berRead(b)
+	if err != nil {
+		return nil, err
+	}
+	if t&0xe0 != 0xa0 {
+		return nil, fmt.Errorf("not a PDU: 0x%02x", t)
+	}
+
+	p := &snmpPDU{Type: t}
+	var v int64
+	if v, c, err = berReadInt(c); err != nil {
+		return nil, err
+	}
+	p.RequestID = int32(v)
+	if v, c, err = berReadInt(c); err != nil {
+		return nil, err
+	}
+	p.ErrorStatus = int(v)
+	if v, c, err = berReadInt(c); err != nil {
+		return nil, err
+	}
+	p.ErrorIndex = int(v)
+
+	list, _, err := berExpect(c, berSequence)
+	if err != nil {
+		return nil, err
+	}
+	for len(list) > 0 {
+		var vb []byte
+		if vb, list, err = berExpect(list, berSequence); err != nil {
+			return nil, err
+		}
+		o, val, err := berReadOID(vb)
+		if err != nil {
+			return nil, err
+		}
+		p.VarBinds = append(p.VarBinds, snmpVarBind{OID: o, Value: val})
+	}
+	return p, nil
+}
@@ -2,7 +2,6 @@ package passpersist
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log/slog"
 	"sync"
@@ -10,114 +9,329 @@ import (
 
 func NewCache() *Cache {
 	return &Cache{
-		staged:    make(map[string]*VarBind),
-		committed: make(map[string]*VarBind),
+		staged:    newOIDTree(),
+		committed: newOIDTree(),
 	}
 }
 
 type Cache struct {
 	sync.RWMutex
-	staged    map[string]*VarBind
-	committed map[string]*VarBind
-	index     OIDs
+	staged      *oidTree
+	committed   *oidTree
+	subscribers []func(Diff)
+
+	// strict rejects conflicting entries, see SetStrict
+	strict       bool
+	stagedErrors []error
+	errors       []error
+
+	// maxEntries caps the staged entries, see SetMaxEntries
+	maxEntries    int
+	stagedDropped int
+	dropped       int
+
+	// position of the last entry returned by GetNext or Walk, walks
+	// continue from here without searching the tree
+	cursorMu sync.Mutex
+	cursor   *oidIter
 }
 
-func (c *Cache) getIndex(o OID) (int, error) {
-	for p, v := range c.index {
-		if v.Equal(o) {
-			return p, nil
-		}
+// ConflictError is returned by Set in strict mode for an OID staged twice
+// in one refresh, or that is a leaf and a prefix of another leaf
+type ConflictError struct {
+	OID  OID
+	With OID
+}
+
+func (e *ConflictError) Error() string {
+	if e.OID.Equal(e.With) {
+		return fmt.Sprintf("duplicate OID %s", e.OID)
 	}
+	return fmt.Sprintf("OID %s conflicts with %s", e.OID, e.With)
+}
+
+// LimitError is returned by Set for entries past the cap of SetMaxEntries
+type LimitError struct {
+	OID OID
+	Max int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("more than %d entries, dropped %s and the entries added after it", e.Max, e.OID)
+}
+
+// Change holds the previous and current VarBind of a changed value
+type Change struct {
+	Old *VarBind `json:"old"`
+	New *VarBind `json:"new"`
+}
+
+// Diff describes what changed between two commits
+type Diff struct {
+	Added   []*VarBind `json:"added"`
+	Removed []*VarBind `json:"removed"`
+	Changed []Change   `json:"changed"`
+}
+
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
 
-	for p, v := range c.index {
-		if v.StartsWith(o) {
-			return p - 1, nil
+// diff merges two ordered lists of VarBinds
+func diff(old []*VarBind, new []*VarBind) Diff {
+	var d Diff
+
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case j == len(new):
+			d.Removed = append(d.Removed, old[i])
+			i++
+		case i == len(old):
+			d.Added = append(d.Added, new[j])
+			j++
+		default:
+			switch c := old[i].OID.Compare(new[j].OID); {
+			case c < 0:
+				d.Removed = append(d.Removed, old[i])
+				i++
+			case c > 0:
+				d.Added = append(d.Added, new[j])
+				j++
+			default:
+				if !old[i].equalValue(new[j]) {
+					d.Changed = append(d.Changed, Change{Old: old[i], New: new[j]})
+				}
+				i++
+				j++
+			}
 		}
 	}
-	return 0, errors.New("OID or prefix does not exist")
+
+	return d
 }
 
-func (c *Cache) Commit() error {
+// OnChange registers fn to receive the differences found by each Commit,
+// commits without changes are not reported
+func (c *Cache) OnChange(fn func(Diff)) {
 	c.Lock()
 	defer c.Unlock()
 
+	c.subscribers = append(c.subscribers, fn)
+}
+
+func (c *Cache) notify(d Diff, subscribers []func(Diff)) {
+	if d.Empty() {
+		return
+	}
+	slog.Debug("cache changed", "added", len(d.Added), "removed", len(d.Removed), "changed", len(d.Changed))
+	for _, fn := range subscribers {
+		fn(d)
+	}
+}
+
+func (c *Cache) resetCursor() {
+	c.cursorMu.Lock()
+	c.cursor = nil
+	c.cursorMu.Unlock()
+}
+
+// Commit replaces the committed entries with everything staged since the
+// last commit
+func (c *Cache) Commit() error {
+	c.Lock()
+
 	slog.Debug("commiting cache...")
 
+	var d Diff
+	subscribers := c.subscribers
+	if len(subscribers) > 0 {
+		d = diff(c.committed.list(), c.staged.list())
+	}
+
 	c.committed = c.staged
+	c.staged = newOIDTree()
+	c.errors = c.stagedErrors
+	c.stagedErrors = nil
+	c.dropped = c.stagedDropped
+	c.stagedDropped = 0
+	c.resetCursor()
 
-	c.staged = make(map[string]*VarBind)
+	c.Unlock()
 
-	idx := make(OIDs, 0, len(c.committed))
-	for _, vb := range c.committed {
-		idx = append(idx, vb.OID)
+	c.notify(d, subscribers)
+
+	return nil
+}
+
+// CommitSubtree replaces the committed entries under prefix with the ones
+// staged under it, leaving the rest of the cache untouched
+func (c *Cache) CommitSubtree(prefix OID) error {
+	c.Lock()
+
+	slog.Debug("commiting cache subtree...", "prefix", prefix)
+
+	var d Diff
+	subscribers := c.subscribers
+	if len(subscribers) > 0 {
+		d = diff(c.subtree(c.committed, prefix), c.subtree(c.staged, prefix))
 	}
 
-	idx = idx.Sort()
-	c.index = idx
+	c.committed.attach(prefix, c.staged.detach(prefix))
+	c.resetCursor()
+
+	c.Unlock()
+
+	c.notify(d, subscribers)
 
 	return nil
 }
 
-func (c *Cache) DumpIndex() {
+// DeleteSubtree removes all committed entries under prefix
+func (c *Cache) DeleteSubtree(prefix OID) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.committed.detach(prefix)
+	c.resetCursor()
+}
+
+func (c *Cache) subtree(t *oidTree, prefix OID) []*VarBind {
+	var out []*VarBind
+	t.walk(prefix, func(vb *VarBind) bool {
+		out = append(out, vb)
+		return true
+	})
+	return out
+}
+
+// Subtree returns the committed entries under prefix in order
+func (c *Cache) Subtree(prefix OID) []*VarBind {
 	c.RLock()
 	defer c.RUnlock()
 
+	return c.subtree(c.committed, prefix)
+}
+
+// Len returns the number of committed entries
+func (c *Cache) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.committed.size
+}
+
+func (c *Cache) DumpIndex() {
 	slog.Debug("dumping cache index...")
-	slog.Debug("index:", slog.Any("index", c.index))
-	y, _ := json.MarshalIndent(c.index, "", "  ")
+
+	y, _ := json.MarshalIndent(c.index(), "", "  ")
 	fmt.Println(string(y))
 }
 
-func (c *Cache) Dump() {
+func (c *Cache) index() OIDs {
 	c.RLock()
 	defer c.RUnlock()
 
-	o, _ := json.MarshalIndent(c.committed, "", "  ")
+	vbs := c.committed.list()
+	idx := make(OIDs, len(vbs))
+	for i, vb := range vbs {
+		idx[i] = vb.OID
+	}
+	return idx
+}
+
+func (c *Cache) Dump() {
+	o, _ := json.MarshalIndent(c.dumpEntries(), "", "  ")
 	fmt.Println(string(o))
 }
 
+type dumpEntry struct {
+	*VarBind
+	Name string `json:"name,omitempty"`
+}
+
+func (c *Cache) dumpEntries() map[string]dumpEntry {
+	c.RLock()
+	defer c.RUnlock()
+
+	m := make(map[string]dumpEntry, c.committed.size)
+	for _, vb := range c.committed.list() {
+		e := dumpEntry{VarBind: vb}
+		if n := DefaultMIBResolver.Name(vb.OID); n != vb.OID.String() {
+			e.Name = n
+		}
+		m[vb.OID.String()] = e
+	}
+	return m
+}
+
 func (c *Cache) Get(oid OID) *VarBind {
 	c.RLock()
 	defer c.RUnlock()
 
-	slog.Debug("getting value at oid", "oid", oid.String())
-	if v, ok := c.committed[oid.String()]; ok {
-		slog.Debug("got value", "oid", oid.String(), "value", v.Value.String())
+	slog.Debug("getting value at oid", "oid", oid)
+	if v := c.committed.get(oid); v != nil {
+		slog.Debug("got value", "oid", oid, "value", &v.Value)
 		return v
 	}
 	return nil
 }
 
+// next returns the entry following oid and leaves the cursor on it, the
+// caller must hold the read lock
+func (c *Cache) next(oid OID) *VarBind {
+	c.cursorMu.Lock()
+	defer c.cursorMu.Unlock()
+
+	if c.cursor != nil && c.cursor.cur != nil && c.cursor.cur.OID.Equal(oid) {
+		return c.cursor.advance()
+	}
+
+	v := c.committed.next(oid)
+	if v != nil {
+		c.cursor = c.committed.seek(v.OID)
+	}
+	return v
+}
+
 func (c *Cache) GetNext(oid OID) *VarBind {
 	c.RLock()
 	defer c.RUnlock()
 
-	slog.Debug("getting next value after", "oid", oid.String())
+	slog.Debug("getting next value after", "oid", oid)
 
-	idx, err := c.getIndex(oid)
-	slog.Debug("got index at", "oid", oid.String(), "index", idx)
-	if err != nil {
-		slog.Warn("failed to get index", slog.Any("error", err.Error()), "oid", oid.String())
-		return nil
+	v := c.next(oid)
+	if v == nil {
+		slog.Debug("no entry after oid", "oid", oid)
 	}
+	return v
+}
 
-	nidx := idx + 1
+// Walk returns up to n committed entries following from, in order.
+// Passing the last OID of the result continues the walk.
+func (c *Cache) Walk(from OID, n int) []*VarBind {
+	c.RLock()
+	defer c.RUnlock()
 
-	slog.Debug("getting next after", "idx", idx, "next-idx", nidx)
+	if n <= 0 {
+		return nil
+	}
+	if n > c.committed.size {
+		n = c.committed.size
+	}
 
-	if nidx < len(c.index) {
-		next := c.index[nidx]
-		if v, ok := c.committed[next.String()]; ok {
-			slog.Debug("got next entry", "oid", next.String(), "val", v.Marshal())
-			return v
-		} else {
-			slog.Debug("no entry for oid", "oid", next.String())
+	out := make([]*VarBind, 0, n)
+	o := from
+	for len(out) < n {
+		v := c.next(o)
+		if v == nil {
+			break
 		}
-	} else {
-		slog.Debug("index out of bounds", "idxLen", len(c.index), "idx", idx)
+		out = append(out, v)
+		o = v.OID
 	}
 
-	return nil
+	return out
 }
 
 func (c *Cache) Set(v *VarBind) error {
@@ -126,7 +340,95 @@ func (c *Cache) Set(v *VarBind) error {
 
 	slog.Debug("staging", slog.Any("value", v.Marshal()))
 
-	c.staged[v.OID.String()] = v
+	if c.strict {
+		if o := c.staged.conflict(v.OID); o != nil {
+			err := &ConflictError{OID: v.OID, With: o.OID}
+			c.stagedErrors = append(c.stagedErrors, err)
+			return err
+		}
+	}
+	if c.maxEntries > 0 && c.staged.size >= c.maxEntries && c.staged.get(v.OID) == nil {
+		err := &LimitError{OID: v.OID, Max: c.maxEntries}
+		// one error per refresh, a runaway collector may add millions
+		if c.stagedDropped == 0 {
+			c.stagedErrors = append(c.stagedErrors, err)
+		}
+		c.stagedDropped++
+		return err
+	}
+	c.staged.set(v)
 
 	return nil
 }
+
+// setUncapped stages v even past the cap, for values describing the
+// cache itself
+func (c *Cache) setUncapped(v *VarBind) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.staged.set(v)
+}
+
+// Rollback drops everything staged since the last commit
+func (c *Cache) Rollback() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.staged = newOIDTree()
+	c.stagedErrors = nil
+	c.stagedDropped = 0
+}
+
+// SetStrict makes Set reject an OID already staged since the last commit
+// and OIDs that are both a leaf and a prefix of another leaf, instead of
+// overwriting silently
+func (c *Cache) SetStrict(strict bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.strict = strict
+}
+
+// SetMaxEntries makes Set reject new entries once n are staged since the
+// last commit, zero removes the cap
+func (c *Cache) SetMaxEntries(n int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.maxEntries = n
+}
+
+// StagedDropped returns the entries rejected by the cap since the last
+// commit
+func (c *Cache) StagedDropped() int {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.stagedDropped
+}
+
+// Dropped returns the entries rejected by the cap in the last committed
+// refresh
+func (c *Cache) Dropped() int {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.dropped
+}
+
+// StagedErrors returns the errors of Set since the last commit
+func (c *Cache) StagedErrors() []error {
+	c.RLock()
+	defer c.RUnlock()
+
+	return append([]error(nil), c.stagedErrors...)
+}
+
+// Errors returns the errors of Set in the last committed refresh
+func (c *Cache) Errors() []error {
+	c.RLock()
+	defer c.RUnlock()
+
+	return append([]error(nil), c.errors...)
+}
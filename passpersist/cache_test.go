@@ -1,6 +1,9 @@
 package passpersist
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestCacheSet(t *testing.T) {
 	c := NewCache()
@@ -27,3 +30,183 @@ func TestCacheSet(t *testing.T) {
 
 	c.Dump()
 }
+
+func TestCacheCommitDiff(t *testing.T) {
+	c := NewCache()
+
+	var diffs []Diff
+	c.OnChange(func(d Diff) {
+		diffs = append(diffs, d)
+	})
+
+	set := func(oid string, v string) {
+		c.Set(NewVarBind(MustNewOID(oid), &StringVal{v}))
+	}
+
+	set("1.3.6.1.4.1.30065.4.226.1", "up")
+	set("1.3.6.1.4.1.30065.4.226.2", "up")
+	c.Commit()
+
+	set("1.3.6.1.4.1.30065.4.226.1", "down")
+	set("1.3.6.1.4.1.30065.4.226.3", "up")
+	c.Commit()
+
+	set("1.3.6.1.4.1.30065.4.226.1", "down")
+	set("1.3.6.1.4.1.30065.4.226.3", "up")
+	c.Commit()
+
+	if len(diffs) != 2 {
+		t.Fatalf("expected 2 diffs, got %d", len(diffs))
+	}
+
+	if len(diffs[0].Added) != 2 {
+		t.Errorf("expected 2 added on first commit, got %d", len(diffs[0].Added))
+	}
+
+	d := diffs[1]
+	if len(d.Added) != 1 || d.Added[0].OID.String() != "1.3.6.1.4.1.30065.4.226.3" {
+		t.Errorf("unexpected added: %v", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].OID.String() != "1.3.6.1.4.1.30065.4.226.2" {
+		t.Errorf("unexpected removed: %v", d.Removed)
+	}
+	if len(d.Changed) != 1 || d.Changed[0].Old.Value.String() != "up" || d.Changed[0].New.Value.String() != "down" {
+		t.Errorf("unexpected changed: %v", d.Changed)
+	}
+}
+
+func TestCacheWalk(t *testing.T) {
+	c := NewCache()
+	base := MustNewOID("1.3.6.1.4.1.30065.4.226")
+	for i := 1; i <= 10; i++ {
+		c.Set(NewVarBind(base.MustAppend([]int{1, i}), &IntVal{int32(i)}))
+	}
+	c.Commit()
+
+	var got []int32
+	o := base
+	for {
+		vbs := c.Walk(o, 3)
+		if len(vbs) == 0 {
+			break
+		}
+		for _, vb := range vbs {
+			got = append(got, vb.Value.GetIntVal())
+		}
+		o = vbs[len(vbs)-1].OID
+	}
+
+	if len(got) != 10 {
+		t.Fatalf("expected 10 entries, got %d", len(got))
+	}
+	for i, v := range got {
+		if v != int32(i+1) {
+			t.Errorf("entry %d out of order: %d", i, v)
+		}
+	}
+
+	// an OID between entries continues with its successor
+	if v := c.GetNext(base.MustAppend([]int{1, 4, 7})); v == nil || v.Value.GetIntVal() != 5 {
+		t.Errorf("unexpected successor %v", v)
+	}
+}
+
+func benchCache(n int) *Cache {
+	c := NewCache()
+	base := MustNewOID("1.3.6.1.4.1.30065.4.226")
+	for i := 0; i < n; i++ {
+		c.Set(NewVarBind(base.MustAppend([]int{1, i%10 + 1, i}), &Counter64Val{uint64(i)}))
+	}
+	c.Commit()
+	return c
+}
+
+func BenchmarkCacheWalkGetNext(b *testing.B) {
+	c := benchCache(10000)
+	base := MustNewOID("1.3.6.1.4.1.30065.4.226")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o := base
+		for v := c.GetNext(o); v != nil; v = c.GetNext(o) {
+			o = v.OID
+		}
+	}
+}
+
+func BenchmarkCacheWalk(b *testing.B) {
+	c := benchCache(10000)
+	base := MustNewOID("1.3.6.1.4.1.30065.4.226")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o := base
+		for vbs := c.Walk(o, 50); len(vbs) > 0; vbs = c.Walk(o, 50) {
+			o = vbs[len(vbs)-1].OID
+		}
+	}
+}
+
+func TestCacheCommitSubtree(t *testing.T) {
+	c := NewCache()
+	base := MustNewOID("1.3.6.1.4.1.30065.4.226")
+
+	c.Set(NewVarBind(base.MustAppend([]int{53, 1}), &StringVal{"vrf"}))
+	c.Set(NewVarBind(base.MustAppend([]int{54, 1}), &StringVal{"dhcp"}))
+	c.Commit()
+
+	c.Set(NewVarBind(base.MustAppend([]int{54, 2}), &StringVal{"dhcp2"}))
+	c.CommitSubtree(base.MustAppend([]int{54}))
+
+	if c.Get(base.MustAppend([]int{53, 1})) == nil {
+		t.Error("entries outside the subtree must be kept")
+	}
+	if c.Get(base.MustAppend([]int{54, 1})) != nil || c.Get(base.MustAppend([]int{54, 2})) == nil {
+		t.Error("subtree was not replaced")
+	}
+	if n := len(c.Subtree(base.MustAppend([]int{54}))); n != 1 {
+		t.Errorf("expected 1 entry under .54, got %d", n)
+	}
+}
+
+func TestCacheStrict(t *testing.T) {
+	c := NewCache()
+	c.SetStrict(true)
+	base := MustNewOID("1.3.6.1.4.1.30065.4.226")
+
+	if err := c.Set(NewVarBind(base.MustAppend([]int{1, 1}), &StringVal{"a"})); err != nil {
+		t.Fatal(err)
+	}
+	for _, subs := range [][]int{
+		{1, 1},    // duplicate
+		{1},       // prefix of a leaf
+		{1, 1, 2}, // below a leaf
+	} {
+		err := c.Set(NewVarBind(base.MustAppend(subs), &StringVal{"b"}))
+		var cerr *ConflictError
+		if !errors.As(err, &cerr) || !cerr.With.Equal(base.MustAppend([]int{1, 1})) {
+			t.Errorf("%v: got %v", subs, err)
+		}
+	}
+	if err := c.Set(NewVarBind(base.MustAppend([]int{1, 2}), &StringVal{"c"})); err != nil {
+		t.Error(err)
+	}
+
+	if n := len(c.StagedErrors()); n != 3 {
+		t.Errorf("expected 3 staged errors, got %d", n)
+	}
+	c.Commit()
+	if n := len(c.Errors()); n != 3 {
+		t.Errorf("expected 3 errors after commit, got %d", n)
+	}
+	if v := c.Get(base.MustAppend([]int{1, 1})); v == nil || v.Value.String() != "a" {
+		t.Errorf("first value must be kept, got %v", v)
+	}
+
+	// a new refresh starts clean
+	if err := c.Set(NewVarBind(base.MustAppend([]int{1, 1}), &StringVal{"a"})); err != nil {
+		t.Error(err)
+	}
+	c.Commit()
+	if n := len(c.Errors()); n != 0 {
+		t.Errorf("expected no errors, got %d", n)
+	}
+}
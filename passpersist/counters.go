@@ -0,0 +1,233 @@
+package passpersist
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type CounterTrackerOption func(*CounterTracker)
+
+// WithCounterState persists the tracker state to path, so exported counters
+// stay monotonic across process restarts
+func WithCounterState(path string) CounterTrackerOption {
+	return func(t *CounterTracker) {
+		t.path = path
+	}
+}
+
+// CounterTracker turns raw counters that can reset, e.g. after
+// 'clear ip dhcp relay counters', into monotonic exported counters. Counters
+// are grouped in rows, each row records its last discontinuity like
+// ifCounterDiscontinuityTime.
+type CounterTracker struct {
+	mu    sync.Mutex
+	path  string
+	start time.Time
+	rows  map[string]*counterRow
+}
+
+type counterRow struct {
+	Discontinuity time.Time                `json:"discontinuity"`
+	LastReset     time.Time                `json:"lastReset,omitempty"`
+	Counters      map[string]*counterState `json:"counters"`
+}
+
+type counterState struct {
+	Last   uint64 `json:"last"`
+	Offset uint64 `json:"offset"`
+}
+
+func NewCounterTracker(opts ...CounterTrackerOption) *CounterTracker {
+	t := &CounterTracker{
+		start: time.Now(),
+		rows:  make(map[string]*counterRow),
+	}
+
+	for _, fn := range opts {
+		fn(t)
+	}
+
+	if t.path != "" {
+		if err := t.load(); err != nil {
+			slog.Warn("failed to load counter state", "path", t.path, slog.Any("error", err))
+		}
+	}
+
+	return t
+}
+
+func (t *CounterTracker) row(name string) *counterRow {
+	r, ok := t.rows[name]
+	if !ok {
+		r = &counterRow{Counters: make(map[string]*counterState)}
+		t.rows[name] = r
+	}
+	return r
+}
+
+// SetLastReset records when the source last reset the counters of a row,
+// e.g. lastResetTime in EOS output. A change is a discontinuity even when
+// the counters grew past their previous readings since. Call it before
+// feeding the row's readings.
+func (t *CounterTracker) SetLastReset(row string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.row(row)
+	if r.LastReset.IsZero() || at.Equal(r.LastReset) {
+		r.LastReset = at
+		return
+	}
+
+	slog.Info("counters reset", "row", row, "was", r.LastReset, "now", at)
+	for _, c := range r.Counters {
+		c.Offset += c.Last
+		c.Last = 0
+	}
+	r.LastReset = at
+	r.Discontinuity = time.Now()
+}
+
+// update feeds a raw reading, width is the bit width of a source that wraps
+// or 0 if it only resets
+func (t *CounterTracker) update(row, name string, raw uint64, width uint) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.row(row)
+	c, ok := r.Counters[name]
+	if !ok {
+		r.Counters[name] = &counterState{Last: raw}
+		return raw
+	}
+
+	if raw < c.Last {
+		if width > 0 && width < 64 && c.Last-raw > uint64(1)<<(width-1) {
+			slog.Debug("counter wrapped", "row", row, "counter", name, "last", c.Last, "raw", raw)
+			c.Offset += uint64(1) << width
+		} else {
+			slog.Info("counter discontinuity", "row", row, "counter", name, "last", c.Last, "raw", raw)
+			c.Offset += c.Last
+			r.Discontinuity = time.Now()
+		}
+	}
+	c.Last = raw
+
+	return c.Offset + c.Last
+}
+
+// Counter64 returns the monotonic value of a counter from a raw reading that
+// may reset. Negative readings are invalid and return the previous value.
+func (t *CounterTracker) Counter64(row, name string, raw int64) uint64 {
+	if raw < 0 {
+		slog.Warn("ignoring negative counter", "row", row, "counter", name, "raw", raw)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if c, ok := t.row(row).Counters[name]; ok {
+			return c.Offset + c.Last
+		}
+		return 0
+	}
+	return t.update(row, name, uint64(raw), 0)
+}
+
+// Counter32 is like Counter64 but wraps the exported value at 2^32
+func (t *CounterTracker) Counter32(row, name string, raw int64) uint32 {
+	return uint32(t.Counter64(row, name, raw) & math.MaxUint32)
+}
+
+// Counter64From32 extends a wrapping 32-bit source counter to 64 bits, a
+// drop of more than half the range is treated as a wrap rather than a reset
+func (t *CounterTracker) Counter64From32(row, name string, raw uint32) uint64 {
+	return t.update(row, name, uint64(raw), 32)
+}
+
+// DiscontinuityTime returns the time since start of the last discontinuity
+// in the row, as used for a TimeStamp column. It is zero when no
+// discontinuity happened since the tracker was created.
+func (t *CounterTracker) DiscontinuityTime(row string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r, ok := t.rows[row]
+	if !ok || r.Discontinuity.Before(t.start) {
+		return 0
+	}
+	return r.Discontinuity.Sub(t.start)
+}
+
+// Forget drops a row that no longer exists
+func (t *CounterTracker) Forget(row string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.rows, row)
+}
+
+func (t *CounterTracker) load() error {
+	b, err := os.ReadFile(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rows := make(map[string]*counterRow)
+	if err := json.Unmarshal(b, &rows); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, r := range rows {
+		if r.Counters == nil {
+			r.Counters = make(map[string]*counterState)
+		}
+		t.rows[name] = r
+	}
+
+	return nil
+}
+
+// Save writes the tracker state if a state file is configured
+func (t *CounterTracker) Save() error {
+	if t.path == "" {
+		return nil
+	}
+
+	t.mu.Lock()
+	b, err := json.Marshal(t.rows)
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), filepath.Base(t.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), t.path)
+}
+
+// AddCounterDiscontinuity adds the discontinuity TimeStamp of a tracked row
+func (p *PassPersist) AddCounterDiscontinuity(subIds []int, t *CounterTracker, row string) error {
+	return p.AddTimeTicks(subIds, t.DiscontinuityTime(row))
+}
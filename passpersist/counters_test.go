@@ -0,0 +1,106 @@
+package passpersist
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCounterTrackerReset(t *testing.T) {
+	ct := NewCounterTracker()
+
+	steps := []struct {
+		raw  int64
+		want uint64
+	}{
+		{100, 100},
+		{150, 150},
+		{-1, 150},
+		{10, 160},
+		{40, 190},
+	}
+
+	for _, s := range steps {
+		if got := ct.Counter64("Vlan10", "received", s.raw); got != s.want {
+			t.Errorf("raw %d: got %d, wanted %d", s.raw, got, s.want)
+		}
+	}
+
+	if ct.DiscontinuityTime("Vlan10") == 0 {
+		t.Error("expected a discontinuity to be recorded")
+	}
+	if ct.DiscontinuityTime("Vlan20") != 0 {
+		t.Error("unexpected discontinuity for unknown row")
+	}
+}
+
+func TestCounterTrackerLastReset(t *testing.T) {
+	ct := NewCounterTracker()
+	reset := time.Unix(1729500000, 0)
+
+	ct.SetLastReset("Vlan10", reset)
+	ct.Counter64("Vlan10", "received", 100)
+	ct.SetLastReset("Vlan10", reset)
+	ct.Counter64("Vlan10", "received", 150)
+	if ct.DiscontinuityTime("Vlan10") != 0 {
+		t.Error("unexpected discontinuity with the same reset time")
+	}
+
+	// cleared and grew past the last reading before the next poll
+	ct.SetLastReset("Vlan10", reset.Add(time.Hour))
+	if got := ct.Counter64("Vlan10", "received", 200); got != 350 {
+		t.Errorf("got %d, wanted 350", got)
+	}
+	if ct.DiscontinuityTime("Vlan10") == 0 {
+		t.Error("expected a discontinuity to be recorded")
+	}
+}
+
+func TestAddCounterDiscontinuity(t *testing.T) {
+	ct := NewCounterTracker()
+	ct.start = time.Now().Add(-time.Minute)
+	ct.Counter64("Vlan10", "received", 100)
+	ct.Counter64("Vlan10", "received", 10)
+
+	p := NewPassPersist(WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")))
+	p.AddCounterDiscontinuity([]int{1}, ct, "Vlan10")
+	p.cache.Commit()
+
+	// snmpd reads TimeTicks as hundredths of a second
+	vb := p.cache.Get(p.baseOID.MustAppend([]int{1}))
+	if got := vb.Value.String(); len(got) != 4 || got[:2] != "60" {
+		t.Errorf("got %q, wanted about 6000", got)
+	}
+}
+
+func TestCounterTrackerWrap32(t *testing.T) {
+	ct := NewCounterTracker()
+
+	ct.Counter64From32("eth0", "in", 4294967000)
+	if got := ct.Counter64From32("eth0", "in", 100); got != 4294967396 {
+		t.Errorf("got %d after wrap", got)
+	}
+	if ct.DiscontinuityTime("eth0") != 0 {
+		t.Error("a wrap is not a discontinuity")
+	}
+
+	if got := ct.Counter32("eth0", "out", 1<<32+5); got != 5 {
+		t.Errorf("got %d, wanted exported counter32 to wrap", got)
+	}
+}
+
+func TestCounterTrackerState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.json")
+
+	ct := NewCounterTracker(WithCounterState(path))
+	ct.Counter64("global", "received", 500)
+	if err := ct.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// counters were cleared while the process was down
+	ct = NewCounterTracker(WithCounterState(path))
+	if got := ct.Counter64("global", "received", 20); got != 520 {
+		t.Errorf("got %d, wanted 520 after restart", got)
+	}
+}
@@ -0,0 +1,354 @@
+package passpersist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWalkMax bounds the entries returned by /walk without max
+var DefaultWalkMax = 1000
+
+// WithDebugAddr serves a JSON debug API on addr, a unix socket when it
+// starts with "/" or a loopback host:port:
+//
+//	GET  /cache                 committed values, like DUMP
+//	GET  /index                 committed OIDs, like DUMPINDEX
+//	GET  /config                configuration, like DUMPCONFIG
+//	GET  /status                refresh status of each subtree
+//	GET  /walk?from=&max=       getnext from an OID, like snmpwalk
+//	POST /refresh               refresh everything now
+//	POST /diag?kind=&seconds=   write a diagnostic, see WithDiagDir
+func WithDebugAddr(addr string) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.debugAddr = addr
+	}
+}
+
+// refreshStatus is what /status shows about the refreshes of a PassPersist
+type refreshStatus struct {
+	mu        sync.Mutex
+	running   bool
+	refreshes uint64
+	failures  uint64
+	last      time.Time
+	duration  time.Duration
+	lastError string
+}
+
+func (s *refreshStatus) begin() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running = true
+	return time.Now()
+}
+
+func (s *refreshStatus) end(start time.Time, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running = false
+	s.refreshes++
+	if !ok {
+		s.failures++
+	}
+	s.last = start
+	s.duration = time.Since(start)
+	if err != nil {
+		s.lastError = err.Error()
+	}
+}
+
+func (p *PassPersist) statusJSON() map[string]any {
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := map[string]any{
+		"base-oid":     p.baseOID,
+		"entries":      p.cache.Len(),
+		"refresh-rate": p.refreshRate.String(),
+		"lazy":         p.lazy != nil,
+		"refreshing":   s.running,
+		"refreshes":    s.refreshes,
+		"failures":     s.failures,
+	}
+	if !s.last.IsZero() {
+		m["last-refresh"] = s.last
+		m["last-duration"] = s.duration.String()
+	}
+	if s.lastError != "" {
+		m["last-error"] = s.lastError
+	}
+	return m
+}
+
+// triggerRefresh starts a refresh now instead of at the next scheduled
+// time, it returns false if one is already pending
+func (p *PassPersist) triggerRefresh() bool {
+	if l := p.lazy; l != nil {
+		l.mu.Lock()
+		l.last = time.Time{}
+		l.mu.Unlock()
+		return p.refreshIfStale() != nil
+	}
+	if p.trigger == nil {
+		return false
+	}
+	select {
+	case p.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitNext waits for d or a triggered refresh, it returns false if ctx is
+// done first
+func (p *PassPersist) waitNext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	case <-p.trigger:
+		slog.Info("refresh triggered", "base-oid", p.baseOID)
+		return true
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	o, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, string(o))
+}
+
+// walk returns up to max entries after from, across subtrees
+func (p *PassPersist) walk(from OID, max int) []dumpEntry {
+	var out []dumpEntry
+	for len(out) < max {
+		vb := p.getNext(from)
+		if vb == nil {
+			break
+		}
+		e := dumpEntry{VarBind: vb}
+		if n := DefaultMIBResolver.Name(vb.OID); n != vb.OID.String() {
+			e.Name = n
+		}
+		out = append(out, e)
+		from = vb.OID
+	}
+	return out
+}
+
+func (p *PassPersist) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	get := func(path string, f func(w http.ResponseWriter, r *http.Request)) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			f(w, r)
+		})
+	}
+
+	get("/cache", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		p.dump(w)
+	})
+	get("/index", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		p.dumpIndex(w)
+	})
+	get("/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		p.dumpConfig(w)
+	})
+	get("/status", func(w http.ResponseWriter, r *http.Request) {
+		status := []map[string]any{p.statusJSON()}
+		for _, pp := range p.subtreePPs() {
+			status = append(status, pp.statusJSON())
+		}
+		writeJSON(w, status)
+	})
+	get("/walk", func(w http.ResponseWriter, r *http.Request) {
+		from := p.baseOID
+		if s := r.URL.Query().Get("from"); s != "" {
+			o, err := DefaultMIBResolver.Resolve(s)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			from = o
+		}
+		max := DefaultWalkMax
+		if s := r.URL.Query().Get("max"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid max", http.StatusBadRequest)
+				return
+			}
+			max = n
+		}
+		writeJSON(w, p.walk(from, max))
+	})
+	mux.HandleFunc("/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		triggered := map[string]bool{}
+		for _, pp := range append([]*PassPersist{p}, p.subtreePPs()...) {
+			triggered[pp.baseOID.String()] = pp.triggerRefresh()
+		}
+		writeJSON(w, triggered)
+	})
+	mux.HandleFunc("/diag", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		kind := r.URL.Query().Get("kind")
+		if _, ok := diagKinds[kind]; !ok {
+			http.Error(w, "invalid kind", http.StatusBadRequest)
+			return
+		}
+		var d time.Duration
+		if s := r.URL.Query().Get("seconds"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid seconds", http.StatusBadRequest)
+				return
+			}
+			d = time.Duration(n) * time.Second
+		}
+		path, err := p.writeDiag(kind, d)
+		if errors.Is(err, errCPUProfiling) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]string{"path": path})
+	})
+	return mux
+}
+
+// debugListen listens on a unix socket or a loopback address, the debug
+// API is not meant to be reachable from the network
+func debugListen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "/") {
+		// a socket left behind by a process that did not shut down
+		// cleanly, anything else at the path is kept
+		if fi, err := os.Lstat(addr); err == nil {
+			if fi.Mode()&os.ModeSocket == 0 {
+				return nil, fmt.Errorf("debug address %s exists and is not a socket", addr)
+			}
+			if err := os.Remove(addr); err != nil {
+				return nil, err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return listenPrivate(addr)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("debug address %s is not a loopback address", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenPrivate creates the socket 0600 in a directory only this process
+// can enter and then moves it to addr, so it is never reachable by others.
+// The umask would do it in place but is process wide, files created
+// meanwhile by other goroutines would get its mode too.
+func listenPrivate(addr string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(addr), ".debug-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	ul := ln.(*net.UnixListener)
+	// it would unlink tmp, which is gone once moved
+	ul.SetUnlinkOnClose(false)
+	if err = os.Chmod(tmp, 0o600); err == nil {
+		err = os.Rename(tmp, addr)
+	}
+	if err != nil {
+		ul.Close()
+		return nil, err
+	}
+	return &unixListener{ul, addr}, nil
+}
+
+// unixListener removes its socket at path when closed
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// serveDebug runs the debug API until ctx is done
+func (p *PassPersist) serveDebug(ctx context.Context, wg *sync.WaitGroup) error {
+	ln, err := debugListen(p.debugAddr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: p.debugHandler(), ReadHeaderTimeout: 10 * time.Second}
+
+	slog.Info("serving debug API", "addr", ln.Addr().String())
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("debug listener failed", slog.Any("error", err))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}()
+	return nil
+}
@@ -0,0 +1,188 @@
+package passpersist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDebugWalk(t *testing.T) {
+	p := NewPassPersist(WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")))
+	for i := 1; i <= 3; i++ {
+		p.AddInt([]int{i}, int32(i))
+	}
+	if err := p.Refresh(nil); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(p.debugHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/walk?from=1.3.6.1.4.1.8072.2.255.1&max=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []struct{ OID string }
+	err = json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].OID != "1.3.6.1.4.1.8072.2.255.2" || got[1].OID != "1.3.6.1.4.1.8072.2.255.3" {
+		t.Errorf("got %+v", got)
+	}
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/walk?from=bogus", http.StatusBadRequest},
+		{http.MethodGet, "/walk?max=0", http.StatusBadRequest},
+		{http.MethodPost, "/cache", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/refresh", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/status", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s: got %d, wanted %d", tt.method, tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestDebugRefreshTrigger(t *testing.T) {
+	before := runtime.NumGoroutine()
+	sock := filepath.Join(t.TempDir(), "debug.sock")
+
+	var refreshes atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	h := startRun(t, ctx, func(pp *PassPersist) {
+		pp.AddInt([]int{1}, refreshes.Add(1))
+	}, WithDebugAddr(sock), WithSignals())
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}}
+	do := func(method string, path string, v any) {
+		t.Helper()
+		req, _ := http.NewRequest(method, "http://debug"+path, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the socket is created once the refresh loop is running
+	for i := 0; i < 50 && refreshes.Load() < 1; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	var triggered map[string]bool
+	for i := 0; i < 50; i++ {
+		if resp, err := client.Get("http://debug/status"); err == nil {
+			resp.Body.Close()
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	do(http.MethodPost, "/refresh", &triggered)
+	if !triggered["1.3.6.1.4.1.8072.2.255"] {
+		t.Errorf("got %v", triggered)
+	}
+	for i := 0; i < 50 && refreshes.Load() < 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := refreshes.Load(); n != 2 {
+		t.Fatalf("%d refreshes, wanted 2", n)
+	}
+
+	var status []map[string]any
+	for i := 0; i < 50; i++ {
+		do(http.MethodGet, "/status", &status)
+		if len(status) == 1 && status[0]["refreshes"] == float64(2) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(status) != 1 || status[0]["refreshes"] != float64(2) || status[0]["entries"] != float64(1) {
+		t.Errorf("got %v", status)
+	}
+
+	client.CloseIdleConnections()
+	cancel()
+	h.wait(t)
+	checkGoroutines(t, before)
+}
+
+func TestDebugListenLoopback(t *testing.T) {
+	if _, err := debugListen("0.0.0.0:0"); err == nil {
+		t.Error("listening on all addresses")
+	}
+	ln, err := debugListen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+}
+
+func TestDebugListenSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "file")
+	os.WriteFile(path, []byte("keep"), 0o644)
+	if _, err := debugListen(path); err == nil {
+		t.Error("listening in place of a regular file")
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "keep" {
+		t.Errorf("file was removed or changed: %v", err)
+	}
+
+	path = filepath.Join(dir, "debug.sock")
+	ln, err := debugListen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode %v, %v", fi.Mode(), err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("private directory left behind: %v", entries)
+	}
+	ln.Close()
+	if _, err := os.Lstat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket not removed on close: %v", err)
+	}
+
+	// left behind as after a crash
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err = debugListen(path)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	ln.Close()
+}
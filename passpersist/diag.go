@@ -0,0 +1,153 @@
+package passpersist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// DefaultDiagDir is where diagnostics are written without WithDiagDir
+const DefaultDiagDir = "/tmp"
+
+// DefaultCPUProfileDuration is how long a CPU profile runs
+var DefaultCPUProfileDuration = 10 * time.Second
+
+// errCPUProfiling is returned while a CPU profile is being taken
+var errCPUProfiling = errors.New("CPU profile already running")
+
+// cpuProfiling is held while a CPU profile runs, there can be one per
+// process
+var cpuProfiling sync.Mutex
+
+// WithDebug enables commands meant for testing, such as PANIC
+func WithDebug() func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.debug = true
+	}
+}
+
+// WithDiagDir writes diagnostics to dir instead of DefaultDiagDir. The
+// DIAGCPU, DIAGHEAP, DIAGGOROUTINE and DIAGMEMSTATS commands, or /diag of
+// the debug API, write a CPU profile, a heap profile, the stacks of all
+// goroutines or runtime.MemStats there and answer with the file's path.
+func WithDiagDir(dir string) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.diagDir = dir
+	}
+}
+
+// diagKinds maps each diagnostic to its file extension
+var diagKinds = map[string]string{
+	"cpu":       "pprof",
+	"heap":      "pprof",
+	"goroutine": "txt",
+	"memstats":  "json",
+}
+
+// memStats is written by the memstats diagnostic
+type memStats struct {
+	Time       time.Time        `json:"time"`
+	Goroutines int              `json:"goroutines"`
+	GOMAXPROCS int              `json:"gomaxprocs"`
+	MemStats   runtime.MemStats `json:"memstats"`
+}
+
+// diagPath returns a new file name for kind in the diagnostics directory
+func (p *PassPersist) diagPath(kind string) string {
+	dir := p.diagDir
+	if dir == "" {
+		dir = DefaultDiagDir
+	}
+	name := fmt.Sprintf("%s-%d-%s-%s.%s",
+		filepath.Base(os.Args[0]), os.Getpid(), kind, time.Now().Format("20060102-150405.000"), diagKinds[kind])
+	return filepath.Join(dir, name)
+}
+
+// writeDiag writes the diagnostic kind to a file and returns its path. A
+// CPU profile runs in the background for d, or DefaultCPUProfileDuration,
+// and the file is complete once it stops.
+func (p *PassPersist) writeDiag(kind string, d time.Duration) (string, error) {
+	if _, ok := diagKinds[kind]; !ok {
+		return "", fmt.Errorf("unknown diagnostic %q", kind)
+	}
+	if kind == "cpu" && !cpuProfiling.TryLock() {
+		return "", errCPUProfiling
+	}
+
+	path := p.diagPath(kind)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if kind == "cpu" {
+			cpuProfiling.Unlock()
+		}
+		return "", err
+	}
+
+	switch kind {
+	case "cpu":
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			cpuProfiling.Unlock()
+			return "", err
+		}
+		if d <= 0 {
+			d = DefaultCPUProfileDuration
+		}
+		go p.stopCPUProfile(p.Context(), f, d)
+		slog.Info("CPU profile started", "path", path, "duration", d)
+		return path, nil
+	case "heap":
+		runtime.GC()
+		err = pprof.WriteHeapProfile(f)
+	case "goroutine":
+		err = pprof.Lookup("goroutine").WriteTo(f, 2)
+	case "memstats":
+		s := memStats{Time: time.Now(), Goroutines: runtime.NumGoroutine(), GOMAXPROCS: runtime.GOMAXPROCS(0)}
+		runtime.ReadMemStats(&s.MemStats)
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(s)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	slog.Info("wrote diagnostic", "kind", kind, "path", path)
+	return path, nil
+}
+
+// diag answers a DIAG* command with the path written, or NONE
+func (p *PassPersist) diag(w io.Writer, kind string) {
+	path, err := p.writeDiag(kind, 0)
+	if err != nil {
+		slog.Warn("failed to write diagnostic", "kind", kind, slog.Any("error", err))
+		fmt.Fprintln(w, "NONE")
+		return
+	}
+	fmt.Fprintln(w, path)
+}
+
+// stopCPUProfile ends the CPU profile after d, or earlier when Run stops
+func (p *PassPersist) stopCPUProfile(ctx context.Context, f *os.File, d time.Duration) {
+	defer cpuProfiling.Unlock()
+
+	sleepCtx(ctx, d)
+	pprof.StopCPUProfile()
+	if err := f.Close(); err != nil {
+		slog.Warn("failed to write CPU profile", "path", f.Name(), slog.Any("error", err))
+		return
+	}
+	slog.Info("CPU profile done", "path", f.Name())
+}
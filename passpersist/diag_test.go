@@ -0,0 +1,78 @@
+package passpersist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteDiag(t *testing.T) {
+	dir := t.TempDir()
+	p := NewPassPersist(WithDiagDir(dir))
+
+	for _, kind := range []string{"heap", "goroutine", "memstats"} {
+		path, err := p.writeDiag(kind, 0)
+		if err != nil {
+			t.Fatalf("%s: %s", kind, err)
+		}
+		if filepath.Dir(path) != dir || !strings.Contains(filepath.Base(path), "-"+kind+"-") {
+			t.Errorf("%s: unexpected path %s", kind, path)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil || len(b) == 0 {
+			t.Errorf("%s: empty or unreadable: %v", kind, err)
+		}
+		if kind == "memstats" {
+			var s memStats
+			if err := json.Unmarshal(b, &s); err != nil || s.Goroutines == 0 || s.MemStats.HeapAlloc == 0 {
+				t.Errorf("memstats: %v %+v", err, s)
+			}
+		}
+	}
+
+	if _, err := p.writeDiag("bogus", 0); err == nil {
+		t.Error("unknown diagnostic accepted")
+	}
+}
+
+func TestWriteDiagCPU(t *testing.T) {
+	p := NewPassPersist(WithDiagDir(t.TempDir()))
+
+	path, err := p.writeDiag("cpu", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.writeDiag("cpu", 0); !errors.Is(err, errCPUProfiling) {
+		t.Errorf("got %v, wanted %v", err, errCPUProfiling)
+	}
+
+	// held until the profile is written
+	cpuProfiling.Lock()
+	cpuProfiling.Unlock()
+	if fi, err := os.Stat(path); err != nil || fi.Size() == 0 {
+		t.Errorf("empty or missing profile: %v", err)
+	}
+}
+
+func TestDiagCommands(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	h := startRun(t, ctx, nil, WithDiagDir(dir), WithSignals())
+
+	if got := h.request(t, "PANIC"); got != "NONE" {
+		t.Errorf("PANIC without debug: got %q", got)
+	}
+	got := h.request(t, "S")
+	if filepath.Dir(got) != dir || !strings.HasSuffix(got, ".json") {
+		t.Errorf("got %q", got)
+	}
+
+	h.in.Close()
+	h.wait(t)
+}
@@ -0,0 +1,200 @@
+package passpersist
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// EnumValue is a labelled INTEGER value
+type EnumValue struct {
+	Label string
+	Value int32
+}
+
+// Enum maps state strings such as "up" or "notInstalled" to INTEGER values
+// so NMS thresholds can use them. Labels match case-insensitively.
+type Enum struct {
+	// Name is the textual convention in generated MIBs, e.g. OperStatus
+	Name string
+	// Default is returned for unknown strings, it should be one of the
+	// values so it is listed in the MIB
+	Default int32
+
+	values  []EnumValue
+	byLabel map[string]int32
+	// module defining the textual convention when it is a standard one
+	module string
+}
+
+// NewEnum returns an enum of values, unknown strings map to def
+func NewEnum(name string, def int32, values ...EnumValue) *Enum {
+	e := &Enum{Name: name, Default: def, byLabel: make(map[string]int32, len(values))}
+	for _, v := range values {
+		e.values = append(e.values, v)
+		e.byLabel[strings.ToLower(v.Label)] = v.Value
+	}
+	return e
+}
+
+// Value returns the INTEGER for s, or Default if s is unknown
+func (e *Enum) Value(s string) int32 {
+	if v, ok := e.byLabel[strings.ToLower(s)]; ok {
+		return v
+	}
+	slog.Debug("unknown enum value", "enum", e.Name, "value", s)
+	return e.Default
+}
+
+// Label returns the label of v, or an empty string
+func (e *Enum) Label(v int32) string {
+	for _, ev := range e.values {
+		if ev.Value == v {
+			return ev.Label
+		}
+	}
+	return ""
+}
+
+// Values returns the values in definition order
+func (e *Enum) Values() []EnumValue {
+	return append([]EnumValue(nil), e.values...)
+}
+
+// Syntax returns the SMI syntax, e.g. INTEGER { up(1), down(2) }
+func (e *Enum) Syntax() string {
+	vals := e.Values()
+	sort.SliceStable(vals, func(i, j int) bool { return vals[i].Value < vals[j].Value })
+
+	items := make([]string, 0, len(vals))
+	for _, v := range vals {
+		items = append(items, fmt.Sprintf("%s(%d)", mibLabel(v.Label), v.Value))
+	}
+	return "INTEGER { " + strings.Join(items, ", ") + " }"
+}
+
+// mibLabel makes s a valid SMIv2 enumeration label, letters and digits
+// starting with a lowercase letter
+func mibLabel(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < 128 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	l := b.String()
+	switch {
+	case l == "":
+		return "unknown"
+	case l[0] >= 'a' && l[0] <= 'z':
+		return l
+	case l[0] >= 'A' && l[0] <= 'Z':
+		return strings.ToLower(l[:1]) + l[1:]
+	}
+	return "v" + l
+}
+
+// AddEnum adds value as the INTEGER e maps it to. Scalars, sub-ids ending
+// in the .0 instance, are listed in DUMPMIB with e as their syntax.
+func (p *PassPersist) AddEnum(subIds []int, value string, e *Enum) error {
+	if n := len(subIds); n > 1 && subIds[n-1] == 0 {
+		p.addMIBScalar(subIds[:n-1], "INTEGER", e)
+	}
+	return p.AddInt(subIds, e.Value(value))
+}
+
+var (
+	enumsMu sync.RWMutex
+	enums   = make(map[string]*Enum)
+)
+
+// RegisterEnum makes e available by name, it panics if the name is taken
+func RegisterEnum(e *Enum) {
+	enumsMu.Lock()
+	defer enumsMu.Unlock()
+
+	if _, dup := enums[e.Name]; dup {
+		panic(fmt.Sprintf("passpersist: RegisterEnum called twice for %s", e.Name))
+	}
+	enums[e.Name] = e
+}
+
+// LookupEnum returns the enum registered as name
+func LookupEnum(name string) (*Enum, bool) {
+	enumsMu.RLock()
+	defer enumsMu.RUnlock()
+
+	e, ok := enums[name]
+	return e, ok
+}
+
+// Enums returns the registered enum names in order
+func Enums() []string {
+	enumsMu.RLock()
+	defer enumsMu.RUnlock()
+
+	names := make([]string, 0, len(enums))
+	for n := range enums {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enums for common EOS states
+var (
+	// OperStatus follows IF-MIB ifOperStatus, for interfaceStatus and
+	// lineProtocolStatus
+	OperStatus = NewEnum("OperStatus", 4,
+		EnumValue{"up", 1},
+		EnumValue{"down", 2},
+		EnumValue{"testing", 3},
+		EnumValue{"unknown", 4},
+		EnumValue{"dormant", 5},
+		EnumValue{"notPresent", 6},
+		EnumValue{"lowerLayerDown", 7},
+	)
+
+	// LinkStatus is the interface status of 'show interfaces status'
+	LinkStatus = NewEnum("LinkStatus", 0,
+		EnumValue{"unknown", 0},
+		EnumValue{"connected", 1},
+		EnumValue{"notconnect", 2},
+		EnumValue{"disabled", 3},
+		EnumValue{"errdisabled", 4},
+		EnumValue{"inactive", 5},
+	)
+
+	// ProtocolState is the routing and protocol state of 'show vrf'
+	ProtocolState = NewEnum("ProtocolState", 0,
+		EnumValue{"unknown", 0},
+		EnumValue{"up", 1},
+		EnumValue{"down", 2},
+		EnumValue{"notInstalled", 3},
+	)
+
+	// BGPPeerState follows BGP4-MIB bgpPeerState, unknown states are idle
+	BGPPeerState = NewEnum("BgpPeerState", 1,
+		EnumValue{"idle", 1},
+		EnumValue{"connect", 2},
+		EnumValue{"active", 3},
+		EnumValue{"openSent", 4},
+		EnumValue{"openConfirm", 5},
+		EnumValue{"established", 6},
+	)
+
+	// TruthValue follows SNMPv2-TC TruthValue
+	TruthValue = NewEnum("TruthValue", 2,
+		EnumValue{"true", 1},
+		EnumValue{"false", 2},
+	)
+)
+
+func init() {
+	TruthValue.module = "SNMPv2-TC"
+	for _, e := range []*Enum{OperStatus, LinkStatus, ProtocolState, BGPPeerState, TruthValue} {
+		RegisterEnum(e)
+	}
+}
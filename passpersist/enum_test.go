@@ -0,0 +1,55 @@
+package passpersist
+
+import "testing"
+
+func TestEnumValue(t *testing.T) {
+	for _, tt := range []struct {
+		e    *Enum
+		in   string
+		want int32
+	}{
+		{ProtocolState, "up", 1},
+		{ProtocolState, "notInstalled", 3},
+		{ProtocolState, "NOTINSTALLED", 3},
+		{ProtocolState, "sideways", 0},
+		{BGPPeerState, "Established", 6},
+		{BGPPeerState, "OpenSent", 4},
+		{BGPPeerState, "stopped", 1},
+		{LinkStatus, "errdisabled", 4},
+		{OperStatus, "bogus", 4},
+		{TruthValue, "true", 1},
+	} {
+		if got := tt.e.Value(tt.in); got != tt.want {
+			t.Errorf("%s(%q): got %d, wanted %d", tt.e.Name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEnumSyntax(t *testing.T) {
+	e := NewEnum("PortState", 0,
+		EnumValue{"err-disabled", 2},
+		EnumValue{"Up", 1},
+		EnumValue{"", 0},
+	)
+	want := "INTEGER { unknown(0), up(1), errdisabled(2) }"
+	if got := e.Syntax(); got != want {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+	if l := e.Label(2); l != "err-disabled" {
+		t.Errorf("got label %q", l)
+	}
+}
+
+func TestEnumRegistry(t *testing.T) {
+	e, ok := LookupEnum("BgpPeerState")
+	if !ok || e != BGPPeerState {
+		t.Fatal("BgpPeerState is not registered")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a duplicate enum")
+		}
+	}()
+	RegisterEnum(NewEnum("BgpPeerState", 0))
+}
@@ -0,0 +1,140 @@
+package passpersist
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// health holds the refresh statistics served by WithHealth
+type health struct {
+	subs      []int
+	refreshes uint32
+	errors    uint32
+	lastError string
+}
+
+// WithStrict rejects entries that would overwrite a value staged in the
+// same refresh, or that are both a leaf and a prefix of another leaf. The
+// conflicts are logged after each refresh and returned by Refresh. With
+// fatal set the Add* call that conflicts panics, which fails a test at the
+// offending line.
+func WithStrict(fatal bool) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.cache.SetStrict(true)
+		p.strictFatal = fatal
+	}
+}
+
+// DefaultHealthSubs is where the health values are served when limits are
+// set without WithHealth, so hitting them shows
+var DefaultHealthSubs = []int{99}
+
+// WithHealth serves statistics about the refreshes under the base OID plus
+// subs:
+//
+//	subs.1.0 refreshes (Counter32)
+//	subs.2.0 errors in the last refresh (Gauge)
+//	subs.3.0 errors and failed refreshes since start (Counter32)
+//	subs.4.0 last error (STRING)
+//	subs.5.0 values loaded from a snapshot, not refreshed yet (TruthValue)
+//	subs.6.0 values dropped by WithMaxEntries in the last refresh (Gauge)
+func WithHealth(subs []int) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.health = &health{subs: subs}
+	}
+}
+
+// Refresh calls f once and commits its entries, it returns the error
+// passed to Fail or the conflicts found in strict mode. Run refreshes on
+// its own, this is meant for tests and one-shot programs.
+func (p *PassPersist) Refresh(f func(*PassPersist)) error {
+	if f == nil {
+		return p.commit()
+	}
+	_, err := p.refreshOnce(p.Context(), f)
+	return err
+}
+
+// commit makes the staged refresh visible and reports its errors
+func (p *PassPersist) commit() error {
+	errs := p.cache.StagedErrors()
+	for _, err := range errs {
+		slog.Warn("rejected entry", "base-oid", p.baseOID, slog.Any("error", err))
+	}
+	if p.health != nil {
+		p.addHealth(errs)
+	}
+	p.cache.Commit()
+	if p.snapshot != nil {
+		if err := p.saveSnapshot(); err != nil {
+			slog.Warn("failed to save snapshot", "path", p.snapshot.path, slog.Any("error", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// failed counts a failed refresh
+func (h *health) failed(err error) {
+	h.errors++
+	h.lastError = err.Error()
+}
+
+// setHealth stages the health value id, uncapped so it shows when
+// WithMaxEntries drops values
+func (p *PassPersist) setHealth(id int, v isTypedValue) {
+	oid := p.baseOID.MustAppend(append(append([]int(nil), p.health.subs...), id, 0))
+	p.cache.setUncapped(NewVarBind(oid, v))
+}
+
+func (p *PassPersist) addHealth(errs []error) {
+	h := p.health
+	h.refreshes++
+	h.errors += uint32(len(errs))
+	if len(errs) > 0 {
+		h.lastError = errs[len(errs)-1].Error()
+	}
+
+	p.setHealth(1, &Counter32Val{h.refreshes})
+	p.setHealth(2, &GaugeVal{uint32(len(errs))})
+	p.setHealth(3, &Counter32Val{h.errors})
+	p.setHealth(4, &StringVal{h.lastError})
+	p.setHealth(5, &IntVal{TruthValue.Value("false")})
+	p.setHealth(6, &GaugeVal{uint32(p.cache.StagedDropped())})
+}
+
+// publishFailure counts a failed refresh and commits the health values on
+// their own, the other values keep those of the last refresh. Nothing else
+// may be staged. dropped is the number of values WithMaxEntries dropped
+// before the refresh failed.
+func (p *PassPersist) publishFailure(err error, dropped int) {
+	h := p.health
+	if h == nil {
+		return
+	}
+	h.failed(err)
+
+	prefix := p.baseOID.MustAppend(h.subs)
+	for _, vb := range p.cache.Subtree(prefix) {
+		p.cache.setUncapped(vb)
+	}
+	p.setHealth(1, &Counter32Val{h.refreshes})
+	p.setHealth(2, &GaugeVal{1})
+	p.setHealth(3, &Counter32Val{h.errors})
+	p.setHealth(4, &StringVal{h.lastError})
+	p.setHealth(6, &GaugeVal{uint32(dropped)})
+	p.cache.CommitSubtree(prefix)
+}
+
+// healthNames names the health values in WriteMetrics
+var healthNames = []string{
+	"passpersistRefreshes",
+	"passpersistRefreshErrors",
+	"passpersistErrors",
+	"passpersistLastError",
+	"passpersistStale",
+	"passpersistDropped",
+}
+
+func (h *health) staleSubs() []int {
+	return append(append([]int(nil), h.subs...), 5, 0)
+}
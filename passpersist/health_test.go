@@ -0,0 +1,102 @@
+package passpersist
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRefreshStrict(t *testing.T) {
+	p := NewPassPersist(
+		WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")),
+		WithStrict(false),
+		WithHealth([]int{99}),
+		WithEnvOverride(false),
+	)
+
+	collect := func(pp *PassPersist) {
+		// the same column written once per protocol
+		pp.AddString([]int{10, 3}, "ipv4")
+		pp.AddString([]int{10, 3}, "ipv6")
+	}
+	if err := p.Refresh(collect); err == nil {
+		t.Fatal("expected a conflict")
+	}
+
+	for _, tt := range []struct {
+		subs []int
+		want string
+	}{
+		{[]int{99, 1, 0}, "1"},
+		{[]int{99, 2, 0}, "1"},
+		{[]int{99, 3, 0}, "1"},
+		{[]int{99, 4, 0}, "duplicate OID 1.3.6.1.4.1.8072.2.255.10.3"},
+	} {
+		v := p.get(p.baseOID.MustAppend(tt.subs))
+		if v == nil || v.Value.String() != tt.want {
+			t.Errorf("%v: got %v, wanted %s", tt.subs, v, tt.want)
+		}
+	}
+
+	if err := p.Refresh(func(pp *PassPersist) { pp.AddString([]int{10, 3}, "ipv4") }); err != nil {
+		t.Fatal(err)
+	}
+	if v := p.get(p.baseOID.MustAppend([]int{99, 2, 0})); v == nil || v.Value.String() != "0" {
+		t.Errorf("last refresh errors: got %v", v)
+	}
+	if v := p.get(p.baseOID.MustAppend([]int{99, 3, 0})); v == nil || v.Value.String() != "1" {
+		t.Errorf("total errors: got %v", v)
+	}
+}
+
+func TestHealthAfterFailure(t *testing.T) {
+	p := NewPassPersist(
+		WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")),
+		WithHealth([]int{99}),
+	)
+	if err := p.Refresh(func(pp *PassPersist) { pp.AddString([]int{1}, "up") }); err != nil {
+		t.Fatal(err)
+	}
+	err := p.Refresh(func(pp *PassPersist) {
+		pp.AddString([]int{1}, "down")
+		pp.Fail(errors.New("show vrf failed"))
+	})
+	if err == nil {
+		t.Fatal("expected the refresh to fail")
+	}
+
+	// health is committed on its own, the values of the last refresh stay
+	for _, tt := range []struct {
+		subs []int
+		want string
+	}{
+		{[]int{1}, "up"},
+		{[]int{99, 1, 0}, "1"},
+		{[]int{99, 2, 0}, "1"},
+		{[]int{99, 3, 0}, "1"},
+		{[]int{99, 4, 0}, "show vrf failed"},
+		{[]int{99, 6, 0}, "0"},
+	} {
+		v := p.get(p.baseOID.MustAppend(tt.subs))
+		if v == nil || v.Value.String() != tt.want {
+			t.Errorf("%v: got %v, wanted %s", tt.subs, v, tt.want)
+		}
+	}
+}
+
+func TestStrictFatal(t *testing.T) {
+	p := NewPassPersist(
+		WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")),
+		WithStrict(true),
+		WithEnvOverride(false),
+	)
+
+	defer func() {
+		if _, ok := recover().(*ConflictError); !ok {
+			t.Error("expected a ConflictError panic")
+		}
+	}()
+	p.Refresh(func(pp *PassPersist) {
+		pp.AddString([]int{1}, "a")
+		pp.AddString([]int{1, 1}, "b")
+	})
+}
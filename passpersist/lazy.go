@@ -0,0 +1,149 @@
+package passpersist
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultLazyDeadline bounds how long a request waits for an on-demand
+// refresh when WithLazy is given no deadline
+var DefaultLazyDeadline = time.Second
+
+// lazy refreshes a cache when it is read instead of on a timer
+type lazy struct {
+	minInterval time.Duration
+	deadline    time.Duration
+
+	mu       sync.Mutex
+	last     time.Time
+	inflight chan struct{}
+
+	// set by Run
+	ctx     context.Context
+	wg      *sync.WaitGroup
+	collect func(*PassPersist)
+}
+
+// WithLazy refreshes on demand instead of every refresh rate. A get or
+// getnext reading values older than minInterval starts a refresh and waits
+// up to deadline for it, requests arriving meanwhile share that refresh.
+// Past the deadline the previous values are served. Nothing runs while
+// nobody polls, after the first refresh at startup.
+func WithLazy(minInterval time.Duration, deadline time.Duration) func(*PassPersist) {
+	return func(p *PassPersist) {
+		if deadline <= 0 {
+			deadline = DefaultLazyDeadline
+		}
+		p.lazy = &lazy{minInterval: minInterval, deadline: deadline}
+	}
+}
+
+// startRefresh refreshes with f on a timer, or once and then on demand in
+// lazy mode
+func (p *PassPersist) startRefresh(ctx context.Context, wg *sync.WaitGroup, f func(*PassPersist)) {
+	if p.lazy == nil {
+		p.trigger = make(chan struct{}, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.update(ctx, f)
+		}()
+		return
+	}
+
+	if err := setPrio(15); err != nil {
+		slog.Warn("failed to set priority")
+	}
+	l := p.lazy
+	l.mu.Lock()
+	l.ctx, l.wg, l.collect = ctx, wg, f
+	l.mu.Unlock()
+	p.refreshIfStale()
+}
+
+// refreshIfStale starts a refresh unless one is running or the last one is
+// recent, it returns a channel closed when the running refresh is done or
+// nil if there is none
+func (p *PassPersist) refreshIfStale() <-chan struct{} {
+	l := p.lazy
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.collect == nil || l.ctx.Err() != nil {
+		return nil
+	}
+	if l.inflight != nil {
+		return l.inflight
+	}
+	if !l.last.IsZero() && time.Since(l.last) < l.minInterval {
+		return nil
+	}
+
+	done := make(chan struct{})
+	l.inflight = done
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		defer close(done)
+
+		slog.Debug("on demand refresh", "base-oid", p.baseOID)
+		p.refreshOnce(l.ctx, l.collect)
+
+		l.mu.Lock()
+		l.last = time.Now()
+		l.inflight = nil
+		l.mu.Unlock()
+	}()
+	return done
+}
+
+// lazyTargets returns the lazy PassPersists a get, or a getnext when next
+// is set, for oid may read
+func (p *PassPersist) lazyTargets(oid OID, next bool) []*PassPersist {
+	var out []*PassPersist
+	add := func(pp *PassPersist) {
+		if pp.lazy != nil {
+			out = append(out, pp)
+		}
+	}
+	if !next {
+		add(p.route(oid))
+		return out
+	}
+	add(p)
+	for _, t := range p.subtrees {
+		if t.pp == nil {
+			continue
+		}
+		// same as nextAcross, subtrees ending before oid are not read
+		if b := t.pp.baseOID; oid.Compare(b) > 0 && !oid.Contains(b) {
+			continue
+		}
+		add(t.pp)
+	}
+	return out
+}
+
+// fresh refreshes the stale caches a request for oid reads and waits for
+// them up to the deadline
+func (p *PassPersist) fresh(oid OID, next bool) {
+	var timer *time.Timer
+	for _, pp := range p.lazyTargets(oid, next) {
+		done := pp.refreshIfStale()
+		if done == nil {
+			continue
+		}
+		if timer == nil {
+			timer = time.NewTimer(pp.lazy.deadline)
+			defer timer.Stop()
+		}
+		select {
+		case <-done:
+		case <-timer.C:
+			slog.Warn("on demand refresh missed the deadline, serving previous values", "base-oid", pp.baseOID)
+			return
+		}
+	}
+}
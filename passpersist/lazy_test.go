@@ -0,0 +1,146 @@
+package passpersist
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startLazy refreshes p with f in lazy mode until the test ends
+func startLazy(t *testing.T, p *PassPersist, f func(*PassPersist)) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	p.ctx = ctx
+	p.startRefresh(ctx, &wg, f)
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+}
+
+func newLazy(min time.Duration, deadline time.Duration) *PassPersist {
+	return NewPassPersist(
+		WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")),
+		WithLazy(min, deadline),
+		WithEnvOverride(false),
+	)
+}
+
+func TestLazyIdleAndOnDemand(t *testing.T) {
+	p := newLazy(20*time.Millisecond, time.Second)
+
+	var n atomic.Int32
+	startLazy(t, p, func(pp *PassPersist) {
+		pp.AddInt([]int{1}, n.Add(1))
+	})
+
+	oid := p.baseOID.MustAppend([]int{1})
+	if v := p.get(oid); v == nil || v.Value.String() != "1" {
+		t.Fatalf("got %v after the first refresh", v)
+	}
+
+	// nobody polls, nothing runs
+	time.Sleep(60 * time.Millisecond)
+	if c := n.Load(); c != 1 {
+		t.Fatalf("refreshed %d times while idle", c)
+	}
+
+	// stale values are refreshed before answering
+	if v := p.get(oid); v == nil || v.Value.String() != "2" {
+		t.Errorf("got %v, wanted a fresh value", v)
+	}
+	// within the minimum interval the cache is served as is
+	if v := p.getNext(p.baseOID); v == nil || v.Value.String() != "2" {
+		t.Errorf("got %v, wanted the cached value", v)
+	}
+	if c := n.Load(); c != 2 {
+		t.Errorf("refreshed %d times, wanted 2", c)
+	}
+}
+
+func TestLazySingleFlight(t *testing.T) {
+	p := newLazy(0, time.Second)
+
+	var n atomic.Int32
+	startLazy(t, p, func(pp *PassPersist) {
+		n.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		pp.AddString([]int{1}, "up")
+	})
+	// wait for the startup refresh
+	p.get(p.baseOID)
+	n.Store(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.getNext(p.baseOID)
+		}()
+	}
+	wg.Wait()
+
+	if c := n.Load(); c != 1 {
+		t.Errorf("concurrent requests ran %d refreshes, wanted 1", c)
+	}
+}
+
+func TestLazyDeadline(t *testing.T) {
+	p := newLazy(0, 30*time.Millisecond)
+
+	var n atomic.Int32
+	startLazy(t, p, func(pp *PassPersist) {
+		if n.Add(1) == 1 {
+			pp.AddString([]int{1}, "old")
+			return
+		}
+		// later refreshes hang until shutdown
+		<-pp.Context().Done()
+	})
+	p.get(p.baseOID)
+
+	start := time.Now()
+	v := p.get(p.baseOID.MustAppend([]int{1}))
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("request took %s", d)
+	}
+	if v == nil || v.Value.String() != "old" {
+		t.Errorf("got %v, wanted the previous value", v)
+	}
+}
+
+func TestLazySubtrees(t *testing.T) {
+	p := newLazy(time.Hour, time.Second)
+
+	var a, b atomic.Int32
+	if _, err := p.AddSubtree([]int{1}, 0, func(pp *PassPersist) { pp.AddInt([]int{1}, a.Add(1)) }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.AddSubtree([]int{2}, 0, func(pp *PassPersist) { pp.AddInt([]int{1}, b.Add(1)) }); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	p.startSubtrees(ctx, &wg)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// a walk reads both subtrees
+	v := p.getNext(p.baseOID)
+	if v == nil || !v.OID.Equal(p.baseOID.MustAppend([]int{1, 1})) {
+		t.Fatalf("got %v", v)
+	}
+	if v := p.getNext(v.OID); v == nil || !v.OID.Equal(p.baseOID.MustAppend([]int{2, 1})) {
+		t.Errorf("got %v", v)
+	}
+	if a.Load() != 1 || b.Load() != 1 {
+		t.Errorf("refreshes: %d, %d", a.Load(), b.Load())
+	}
+}
@@ -0,0 +1,104 @@
+package passpersist
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+)
+
+// LimitPolicy says what happens to a refresh adding more entries than
+// WithMaxEntries allows
+type LimitPolicy int
+
+const (
+	// DropExcess keeps the entries added first and drops the rest
+	DropExcess LimitPolicy = iota
+	// FailRefresh fails the refresh, the previous values are kept
+	FailRefresh
+)
+
+func (l LimitPolicy) String() string {
+	if l == FailRefresh {
+		return "fail"
+	}
+	return "drop"
+}
+
+// limits guard the switch against a runaway extension, they are logged and
+// shown in the health subtree when hit. Setting the memory limit or
+// WithMaxEntries serves the health values at DefaultHealthSubs unless
+// WithHealth places them elsewhere.
+type limits struct {
+	memory     int64
+	maxProcs   int
+	maxEntries int
+	policy     LimitPolicy
+}
+
+// WithMemoryLimit sets a soft memory limit of bytes for the process, the
+// GC works harder as it is approached. Refreshes are skipped, keeping the
+// previous values, while live heap objects alone exceed it.
+func WithMemoryLimit(bytes int64) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.limits.memory = bytes
+	}
+}
+
+// WithMaxProcs caps GOMAXPROCS to n, it never raises it
+func WithMaxProcs(n int) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.limits.maxProcs = n
+	}
+}
+
+// WithMaxEntries caps the entries a refresh may add, to each subtree
+// separately. policy says what happens to a refresh adding more.
+func WithMaxEntries(n int, policy LimitPolicy) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.limits.maxEntries = n
+		p.limits.policy = policy
+		p.cache.SetMaxEntries(n)
+	}
+}
+
+// applyLimits sets the process wide limits, called once by Run
+func (p *PassPersist) applyLimits() {
+	if l := p.limits.memory; l > 0 {
+		debug.SetMemoryLimit(l)
+		slog.Info("memory limit set", "bytes", l)
+	}
+	if n := p.limits.maxProcs; n > 0 && n < runtime.GOMAXPROCS(0) {
+		runtime.GOMAXPROCS(n)
+		slog.Info("GOMAXPROCS capped", "n", n)
+	}
+}
+
+// heapObjectsMetric counts the bytes of live and not yet swept objects
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// overMemoryLimit returns an error when the heap exceeds the memory limit
+func (p *PassPersist) overMemoryLimit() error {
+	if p.limits.memory <= 0 {
+		return nil
+	}
+	heap := func() uint64 {
+		s := []metrics.Sample{{Name: heapObjectsMetric}}
+		metrics.Read(s)
+		if s[0].Value.Kind() != metrics.KindUint64 {
+			return 0
+		}
+		return s[0].Value.Uint64()
+	}
+	limit := uint64(p.limits.memory)
+	if heap() <= limit {
+		return nil
+	}
+	// the objects may be garbage not collected yet
+	runtime.GC()
+	if h := heap(); h > limit {
+		return fmt.Errorf("heap of %d bytes exceeds the memory limit of %d", h, limit)
+	}
+	return nil
+}
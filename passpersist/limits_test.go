@@ -0,0 +1,107 @@
+package passpersist
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCacheMaxEntries(t *testing.T) {
+	c := NewCache()
+	c.SetMaxEntries(2)
+
+	base := MustNewOID("1.3.6.1.4.1.8072.2.255")
+	set := func(sub int, v int32) error {
+		return c.Set(NewVarBind(base.MustAppend([]int{sub}), &IntVal{v}))
+	}
+	for i := 1; i <= 2; i++ {
+		if err := set(i, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// replacing a staged value does not count
+	if err := set(2, 1); err != nil {
+		t.Errorf("replace at the cap: %v", err)
+	}
+	var le *LimitError
+	for i := 3; i <= 5; i++ {
+		if err := set(i, 0); !errors.As(err, &le) {
+			t.Errorf("%d: got %v, wanted a LimitError", i, err)
+		}
+	}
+	if n := len(c.StagedErrors()); n != 1 {
+		t.Errorf("%d staged errors, wanted 1", n)
+	}
+
+	c.Commit()
+	if c.Len() != 2 || c.Dropped() != 3 {
+		t.Errorf("got %d entries and %d dropped", c.Len(), c.Dropped())
+	}
+}
+
+func TestMaxEntriesPolicy(t *testing.T) {
+	tests := []struct {
+		policy LimitPolicy
+		// committed entries besides the health subtree
+		want    int
+		dropped uint32
+	}{
+		{DropExcess, 2, 2},
+		{FailRefresh, 1, 2},
+	}
+	for _, tt := range tests {
+		p := NewPassPersist(
+			WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")),
+			WithMaxEntries(2, tt.policy),
+			WithHealth([]int{9}),
+			WithEnvOverride(false),
+		)
+		if err := p.Refresh(func(pp *PassPersist) { pp.AddInt([]int{1}, 1) }); err != nil {
+			t.Fatal(err)
+		}
+		err := p.Refresh(func(pp *PassPersist) {
+			for i := 1; i <= 4; i++ {
+				pp.AddInt([]int{i}, 2)
+			}
+		})
+		if err == nil {
+			t.Errorf("%s: refresh past the cap succeeded", tt.policy)
+		}
+
+		if n := len(p.cache.Subtree(p.baseOID.MustAppend([]int{1}))); n != 1 {
+			t.Errorf("%s: got %d entries, wanted 1", tt.policy, n)
+		}
+		n := 0
+		for i := 1; i <= 4; i++ {
+			if p.cache.Get(p.baseOID.MustAppend([]int{i})) != nil {
+				n++
+			}
+		}
+		if n != tt.want {
+			t.Errorf("%s: got %d entries, wanted %d", tt.policy, n, tt.want)
+		}
+
+		dropped := p.cache.Get(p.baseOID.MustAppend([]int{9, 6, 0}))
+		if dropped == nil || dropped.Value.GetValue().(*GaugeVal).Value != tt.dropped {
+			t.Errorf("%s: dropped %v, wanted %d", tt.policy, dropped, tt.dropped)
+		}
+		if p.health.errors != 1 {
+			t.Errorf("%s: %d errors counted, wanted 1", tt.policy, p.health.errors)
+		}
+	}
+}
+
+func TestMemoryLimitSkipsRefresh(t *testing.T) {
+	// health is served at DefaultHealthSubs without WithHealth
+	p := NewPassPersist(WithMemoryLimit(1), WithEnvOverride(false))
+
+	called := false
+	if err := p.Refresh(func(pp *PassPersist) { called = true }); err == nil {
+		t.Error("refresh over the memory limit succeeded")
+	}
+	if called {
+		t.Error("collector ran over the memory limit")
+	}
+	if v := p.get(p.baseOID.MustAppend([]int{99, 3, 0})); v == nil || v.Value.String() != "1" {
+		t.Errorf("skipped refresh not counted: %v", v)
+	}
+}
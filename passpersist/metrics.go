@@ -0,0 +1,441 @@
+package passpersist
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenMetricsContentType is served by the metrics listener
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// WithMetrics serves the committed values as OpenMetrics on
+// http://addr/metrics next to pass_persist or the agent
+func WithMetrics(addr string) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.metricsAddr = addr
+	}
+}
+
+// metricFamily is a metric and its samples, families must not interleave
+type metricFamily struct {
+	name    string
+	typ     string
+	samples []string
+}
+
+type metricSet struct {
+	families map[string]*metricFamily
+	order    []string
+}
+
+func (s *metricSet) add(name string, typ string, suffix string, labels [][2]string, value string) {
+	f, ok := s.families[name]
+	if !ok {
+		f = &metricFamily{name: name, typ: typ}
+		s.families[name] = f
+		s.order = append(s.order, name)
+	}
+	if f.typ != typ {
+		// the same name with another type would be rejected by scrapers
+		return
+	}
+	f.samples = append(f.samples, name+suffix+formatLabels(labels)+" "+value)
+}
+
+func formatLabels(labels [][2]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	items := make([]string, 0, len(labels))
+	for _, l := range labels {
+		items = append(items, fmt.Sprintf("%s=\"%s\"", metricName(l[0]), escapeLabel(l[1])))
+	}
+	return "{" + strings.Join(items, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// metricName replaces characters Prometheus does not allow in names
+func metricName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		ok := c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9'
+		if !ok {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// metricValue returns the sample type and value of vb, or false for
+// values that are not numbers
+func metricValue(vb *VarBind) (string, string, bool) {
+	switch x := vb.Value.GetValue().(type) {
+	case *Counter32Val:
+		return "counter", strconv.FormatUint(uint64(x.Value), 10), true
+	case *Counter64Val:
+		return "counter", strconv.FormatUint(x.Value, 10), true
+	case *GaugeVal:
+		return "gauge", strconv.FormatUint(uint64(x.Value), 10), true
+	case *IntVal:
+		return "gauge", strconv.FormatInt(int64(x.Value), 10), true
+	case *TimeTicksVal:
+		return "gauge", strconv.FormatFloat(x.Value.Seconds(), 'f', -1, 64), true
+	}
+	return "", "", false
+}
+
+// isLabelValue reports whether vb is text that makes sense as a label
+func isLabelValue(vb *VarBind) bool {
+	switch vb.Value.GetValue().(type) {
+	case *StringVal, *IPAddrVal, *IPV6AddrVal:
+		return true
+	}
+	return false
+}
+
+func (s *metricSet) addValue(name string, labels [][2]string, vb *VarBind) {
+	typ, v, ok := metricValue(vb)
+	if !ok {
+		return
+	}
+	suffix := ""
+	if typ == "counter" {
+		suffix = "_total"
+	}
+	s.add(metricName(name), typ, suffix, labels, v)
+}
+
+// addTable adds a sample per numeric column of each row, labelled with
+// the row's index objects and its other text columns. Enum columns become
+// statesets, tables with only text columns info metrics. columns holds
+// the columns of every table by name, for indexes made of another table's
+// columns.
+func (s *metricSet) addTable(mt mibTable, cache *Cache, columns map[string]Column) {
+	t := mt.t
+	entry := mt.oid.MustAppend([]int{1})
+
+	rows := map[string]map[int]*VarBind{}
+	var order []OID
+	for _, vb := range cache.Subtree(entry) {
+		if len(vb.OID.Value) <= len(entry.Value)+1 {
+			continue
+		}
+		col := int(vb.OID.Value[len(entry.Value)])
+		sub := OID{vb.OID.Value[len(entry.Value)+1:]}
+		idx := sub.String()
+		if rows[idx] == nil {
+			rows[idx] = map[int]*VarBind{}
+			order = append(order, sub)
+		}
+		rows[idx][col] = vb
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i].Compare(order[j]) < 0 })
+
+	names := t.indexNames()
+	for _, sub := range order {
+		idx := sub.String()
+		row := rows[idx]
+
+		labels, ok := indexLabels(t, names, sub, row, columns)
+		if !ok {
+			labels = [][2]string{{"index", idx}}
+		}
+		labelled := map[string]bool{}
+		for _, l := range labels {
+			labelled[l[0]] = true
+		}
+		for _, id := range t.order {
+			c := t.columns[id]
+			vb, ok := row[id]
+			if !ok || !isLabelValue(vb) || c.Name == "" || labelled[c.Name] {
+				continue
+			}
+			if v := vb.Value.String(); v != "" {
+				labels = append(labels, [2]string{c.Name, v})
+			}
+		}
+
+		numeric := false
+		for _, id := range t.order {
+			c := t.columns[id]
+			vb, ok := row[id]
+			if !ok || c.Name == "" || isLabelValue(vb) {
+				continue
+			}
+			if c.Enum != nil {
+				numeric = true
+				current := vb.Value.GetIntVal()
+				for _, ev := range c.Enum.Values() {
+					v := "0"
+					if ev.Value == current {
+						v = "1"
+					}
+					state := append(append([][2]string(nil), labels...), [2]string{c.Name, ev.Label})
+					s.add(metricName(c.Name), "stateset", "", state, v)
+				}
+				continue
+			}
+			if _, _, ok := metricValue(vb); ok {
+				numeric = true
+				s.addValue(c.Name, labels, vb)
+			}
+		}
+		if !numeric {
+			s.add(metricName(t.entryName()), "info", "_info", labels, "1")
+		}
+	}
+}
+
+// indexLabels decodes the row index sub into a label per index object.
+// It returns false if sub does not match the objects' types, or if an
+// index column of t has another value in the row, as when the default
+// index, the first column, is not the one the rows were created with.
+func indexLabels(t *Table, names []string, sub OID, row map[int]*VarBind, columns map[string]Column) ([][2]string, bool) {
+	own := map[string]int{}
+	for id, c := range t.columns {
+		own[c.Name] = id
+	}
+
+	rest := sub.Value
+	var labels [][2]string
+	for _, name := range names {
+		c, ok := columns[name]
+		if !ok {
+			return nil, false
+		}
+		var v string
+		v, rest, ok = decodeIndex(c.Type, rest)
+		if !ok {
+			return nil, false
+		}
+		if id, ok := own[name]; ok {
+			if vb, ok := row[id]; ok && vb.Value.String() != v {
+				return nil, false
+			}
+		}
+		if v != "" {
+			labels = append(labels, [2]string{name, v})
+		}
+	}
+	return labels, len(rest) == 0
+}
+
+// decodeIndex decodes the first index value of type typ in subs, as
+// encoded by the Index functions, and returns the sub-ids after it
+func decodeIndex(typ string, subs []uint32) (string, []uint32, bool) {
+	switch typ {
+	case "INTEGER", "GAUGE", "Counter32", "TIMETICKS":
+		if len(subs) < 1 {
+			return "", nil, false
+		}
+		if typ == "INTEGER" {
+			return strconv.FormatInt(int64(int32(subs[0])), 10), subs[1:], true
+		}
+		return strconv.FormatUint(uint64(subs[0]), 10), subs[1:], true
+	case "IPADDRESS":
+		if len(subs) < 4 {
+			return "", nil, false
+		}
+		b := make([]byte, 4)
+		for i := range b {
+			if subs[i] > 255 {
+				return "", nil, false
+			}
+			b[i] = byte(subs[i])
+		}
+		return net.IP(b).String(), subs[4:], true
+	case "STRING", "OCTET":
+		if len(subs) < 1 || int(subs[0]) > len(subs)-1 {
+			return "", nil, false
+		}
+		n := int(subs[0])
+		b := make([]byte, n)
+		text := true
+		for i := range b {
+			if subs[1+i] > 255 {
+				return "", nil, false
+			}
+			b[i] = byte(subs[1+i])
+			text = text && b[i] >= 0x20 && b[i] < 0x7f
+		}
+		v := string(b)
+		if !text {
+			// IndexIP encodes IPv6 addresses as 16 octets
+			if a, ok := netip.AddrFromSlice(b); ok && n == 16 {
+				v = a.String()
+			} else {
+				v = hex.EncodeToString(b)
+			}
+		}
+		return v, subs[1+n:], true
+	case "OBJECTID":
+		if len(subs) < 1 || int(subs[0]) > len(subs)-1 {
+			return "", nil, false
+		}
+		n := int(subs[0])
+		return OID{subs[1 : 1+n]}.String(), subs[1+n:], true
+	}
+	return "", nil, false
+}
+
+// addHealth adds the health value id of pp, labelled with its base OID
+// since subtrees have their own
+func (s *metricSet) addHealth(pp *PassPersist, id int, vb *VarBind) {
+	if id < 1 || id > len(healthNames) {
+		return
+	}
+	labels := [][2]string{{"base_oid", pp.baseOID.String()}}
+	if id == 5 {
+		// TruthValue, 2 is false
+		v := "0"
+		if vb.Value.GetIntVal() == TruthValue.Value("true") {
+			v = "1"
+		}
+		s.add(healthNames[id-1], "gauge", "", labels, v)
+		return
+	}
+	s.addValue(healthNames[id-1], labels, vb)
+}
+
+// addUnnamed adds a number without a MIB name as passpersistValue, or
+// passpersistCounter for counters, labelled with its OID below the base OID
+func (s *metricSet) addUnnamed(pp *PassPersist, vb *VarBind) {
+	name := "passpersistValue"
+	if typ, _, _ := metricValue(vb); typ == "counter" {
+		name = "passpersistCounter"
+	}
+	labels := [][2]string{
+		{"base_oid", pp.baseOID.String()},
+		{"oid", OID{vb.OID.Value[len(pp.baseOID.Value):]}.String()},
+	}
+	s.addValue(name, labels, vb)
+}
+
+// WriteMetrics writes the committed values of p and its subtrees in the
+// OpenMetrics text format. Table values are named after their columns,
+// other numbers after their MIB object or, without a name, as
+// passpersistValue or passpersistCounter with an oid label.
+func (p *PassPersist) WriteMetrics(w io.Writer) error {
+	s := &metricSet{families: map[string]*metricFamily{}}
+
+	tables := p.tablesForMIB()
+	columns := map[string]Column{}
+	for _, mt := range tables {
+		for _, c := range mt.t.columns {
+			if c.Name != "" {
+				columns[c.Name] = c
+			}
+		}
+	}
+	for _, mt := range tables {
+		s.addTable(mt, p.route(mt.oid).cache, columns)
+	}
+
+	inTable := func(o OID) bool {
+		for _, mt := range tables {
+			if o.Contains(mt.oid) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, pp := range append([]*PassPersist{p}, p.subtreePPs()...) {
+		var healthOID OID
+		if pp.health != nil {
+			healthOID = pp.baseOID.MustAppend(pp.health.subs)
+		}
+		for _, vb := range pp.cache.Subtree(pp.baseOID) {
+			if inTable(vb.OID) {
+				continue
+			}
+			if pp.health != nil && vb.OID.StartsWith(healthOID) && len(vb.OID.Value) == len(healthOID.Value)+2 {
+				s.addHealth(pp, int(vb.OID.Value[len(healthOID.Value)]), vb)
+				continue
+			}
+			n := DefaultMIBResolver.Name(vb.OID)
+			if n == vb.OID.String() {
+				s.addUnnamed(pp, vb)
+				continue
+			}
+			if _, rest, ok := strings.Cut(n, "::"); ok {
+				n = rest
+			}
+			name, idx, _ := strings.Cut(n, ".")
+			if idx != "" && len(vb.OID.Value)-strings.Count(idx, ".")-1 <= len(pp.baseOID.Value) {
+				// named after the base OID or above, e.g. an enterprise
+				s.addUnnamed(pp, vb)
+				continue
+			}
+			var labels [][2]string
+			if idx != "" && idx != "0" {
+				labels = [][2]string{{"index", idx}}
+			}
+			s.addValue(name, labels, vb)
+		}
+	}
+
+	var b strings.Builder
+	for _, name := range s.order {
+		f := s.families[name]
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.typ)
+		for _, l := range f.samples {
+			b.WriteString(l)
+			b.WriteByte('\n')
+		}
+	}
+	b.WriteString("# EOF\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// serveMetrics runs the metrics listener until ctx is done
+func (p *PassPersist) serveMetrics(ctx context.Context, wg *sync.WaitGroup) error {
+	ln, err := net.Listen("tcp", p.metricsAddr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", OpenMetricsContentType)
+		if err := p.WriteMetrics(w); err != nil {
+			slog.Debug("failed to write metrics", slog.Any("error", err))
+		}
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	slog.Info("serving metrics", "addr", ln.Addr().String())
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics listener failed", slog.Any("error", err))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}()
+	return nil
+}
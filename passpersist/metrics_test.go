@@ -0,0 +1,242 @@
+package passpersist
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	p := NewPassPersist(WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.254")))
+
+	state := NewEnum("MetricsTestState", 2, EnumValue{"up", 1}, EnumValue{"down", 2})
+	ifs, err := p.NewTable("mtIfTable", []int{2},
+		Column{ID: 1, Type: "STRING", Name: "mtIfName"},
+		Column{ID: 2, Type: "Counter64", Name: "mtIfInOctets"},
+		Column{ID: 3, Type: "GAUGE", Name: "mtIfSpeed"},
+		Column{ID: 4, Type: "INTEGER", Name: "mtIfState", Enum: state},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"Et1", `Et"2`} {
+		row := ifs.Row(IndexInt(i + 1))
+		row.Set(1, &StringVal{name})
+		row.Set(2, &Counter64Val{uint64(100 * (i + 1))})
+		row.Set(3, &GaugeVal{1000})
+		row.SetEnum(4, "up")
+	}
+
+	vrfs, err := p.NewTable("mtVrfTable", []int{3},
+		Column{ID: 1, Type: "STRING", Name: "mtVrfName"},
+		Column{ID: 2, Type: "STRING", Name: "mtVrfRD"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := vrfs.Row(IndexString("red"))
+	row.Set(1, &StringVal{"red"})
+	row.Set(2, &StringVal{"65000:1"})
+
+	DefaultMIBResolver.Add("", "mtCount", p.baseOID.MustAppend([]int{1}))
+	p.AddScalar([]int{1}, &GaugeVal{2})
+	p.AddScalar([]int{4}, &GaugeVal{9})
+	if err := p.Refresh(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := p.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE mtIfInOctets counter
+mtIfInOctets_total{index="1",mtIfName="Et1"} 100
+mtIfInOctets_total{index="2",mtIfName="Et\"2"} 200
+# TYPE mtIfSpeed gauge
+mtIfSpeed{index="1",mtIfName="Et1"} 1000
+mtIfSpeed{index="2",mtIfName="Et\"2"} 1000
+# TYPE mtIfState stateset
+mtIfState{index="1",mtIfName="Et1",mtIfState="up"} 1
+mtIfState{index="1",mtIfName="Et1",mtIfState="down"} 0
+mtIfState{index="2",mtIfName="Et\"2",mtIfState="up"} 1
+mtIfState{index="2",mtIfName="Et\"2",mtIfState="down"} 0
+# TYPE mtVrfEntry info
+mtVrfEntry_info{mtVrfName="red",mtVrfRD="65000:1"} 1
+# TYPE mtCount gauge
+mtCount 2
+# TYPE passpersistValue gauge
+passpersistValue{base_oid="1.3.6.1.4.1.8072.2.254",oid="4.0"} 9
+# EOF
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwanted\n%s", b.String(), want)
+	}
+}
+
+func TestWriteMetricsExternalIndex(t *testing.T) {
+	p := NewPassPersist(WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.251")))
+
+	vrfs, err := p.NewTable("mtxVrfTable", []int{2},
+		Column{ID: 1, Type: "STRING", Name: "mtxVrfName"},
+		Column{ID: 2, Type: "STRING", Name: "mtxVrfRD"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protos, err := p.NewTable("mtxProtoTable", []int{3},
+		Column{ID: 1, Type: "STRING", Name: "mtxProtoName"},
+		Column{ID: 2, Type: "Counter64", Name: "mtxProtoRoutes"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protos.SetIndex("mtxVrfName", "mtxProtoName")
+
+	for i, vrf := range []string{"blue", "red"} {
+		row := vrfs.Row(IndexString(vrf))
+		row.Set(1, &StringVal{vrf})
+		// an empty route distinguisher is not a label
+		row.Set(2, &StringVal{""})
+		// the protocol rows only repeat the protocol, not the VRF
+		pr := protos.Row(IndexString(vrf), IndexString("ipv4"))
+		pr.Set(1, &StringVal{"ipv4"})
+		pr.Set(2, &Counter64Val{uint64(10 * (i + 1))})
+	}
+	if err := p.Refresh(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := p.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	// rows are in index order, shorter names first
+	want := `# TYPE mtxVrfEntry info
+mtxVrfEntry_info{mtxVrfName="red"} 1
+mtxVrfEntry_info{mtxVrfName="blue"} 1
+# TYPE mtxProtoRoutes counter
+mtxProtoRoutes_total{mtxVrfName="red",mtxProtoName="ipv4"} 20
+mtxProtoRoutes_total{mtxVrfName="blue",mtxProtoName="ipv4"} 10
+# EOF
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwanted\n%s", b.String(), want)
+	}
+}
+
+func TestDecodeIndex(t *testing.T) {
+	for _, tt := range []struct {
+		typ  string
+		subs []int
+		want string
+		rest int
+		ok   bool
+	}{
+		{"INTEGER", IndexInt(-1 & 0xffffffff), "-1", 0, true},
+		{"GAUGE", append(IndexInt(7), 1), "7", 1, true},
+		{"IPADDRESS", IndexIP(netip.MustParseAddr("10.0.0.1")), "10.0.0.1", 0, true},
+		{"STRING", IndexIP(netip.MustParseAddr("2001:db8::1")), "2001:db8::1", 0, true},
+		{"STRING", append(IndexString("red"), 4), "red", 1, true},
+		{"OCTET", IndexString("\x00\x01"), "0001", 0, true},
+		{"OBJECTID", IndexOID(MustNewOID("1.3.6")), "1.3.6", 0, true},
+		{"STRING", []int{5, 'r', 'e', 'd'}, "", 0, false},
+		{"IPADDRESS", []int{10, 0, 0}, "", 0, false},
+		{"Counter64", IndexInt(1), "", 0, false},
+	} {
+		subs := make([]uint32, len(tt.subs))
+		for i, s := range tt.subs {
+			subs[i] = uint32(s)
+		}
+		got, rest, ok := decodeIndex(tt.typ, subs)
+		if got != tt.want || len(rest) != tt.rest || ok != tt.ok {
+			t.Errorf("%s %v: got %q, %d left, %v", tt.typ, tt.subs, got, len(rest), ok)
+		}
+	}
+}
+
+func TestWriteMetricsWithoutTables(t *testing.T) {
+	// a collector adding values by sub-ids, without tables or names
+	p := NewPassPersist(WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.252")))
+	err := p.Refresh(func(pp *PassPersist) {
+		pp.AddString([]int{1}, "Vlan10")
+		pp.AddCounter64([]int{1, 1}, 900)
+		pp.AddGauge([]int{1, 2}, 3)
+		pp.AddTimeTicks([]int{1, 3}, 1500*time.Millisecond)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := p.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE passpersistCounter counter
+passpersistCounter_total{base_oid="1.3.6.1.4.1.8072.2.252",oid="1.1"} 900
+# TYPE passpersistValue gauge
+passpersistValue{base_oid="1.3.6.1.4.1.8072.2.252",oid="1.2"} 3
+passpersistValue{base_oid="1.3.6.1.4.1.8072.2.252",oid="1.3"} 1.5
+# EOF
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwanted\n%s", b.String(), want)
+	}
+}
+
+func TestMetricName(t *testing.T) {
+	tests := map[string]string{
+		"ifInOctets": "ifInOctets",
+		"1abc":       "_abc",
+		"a-b.c":      "a_b_c",
+	}
+	for in, want := range tests {
+		if got := metricName(in); got != want {
+			t.Errorf("%s: got %s, wanted %s", in, got, want)
+		}
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	h := startRun(t, ctx, func(pp *PassPersist) {
+		pp.AddString([]int{1}, "up")
+	}, WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.253")), WithHealth([]int{9}), WithMetrics(addr), WithSignals())
+
+	// retried until the listener is up and the first refresh committed
+	var body string
+	for i := 0; i < 50 && !strings.Contains(body, "passpersistRefreshes_total"); i++ {
+		time.Sleep(20 * time.Millisecond)
+		resp, err := http.Get("http://" + addr + "/metrics")
+		if err != nil {
+			continue
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		body = string(b)
+		if ct := resp.Header.Get("Content-Type"); ct != OpenMetricsContentType {
+			t.Errorf("got content type %q", ct)
+		}
+	}
+	if !strings.Contains(body, `passpersistRefreshes_total{base_oid="1.3.6.1.4.1.8072.2.253"} 1`) || !strings.HasSuffix(body, "# EOF\n") {
+		t.Errorf("unexpected body\n%s", body)
+	}
+
+	http.DefaultClient.CloseIdleConnections()
+	cancel()
+	h.wait(t)
+	checkGoroutines(t, before)
+}
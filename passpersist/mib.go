@@ -0,0 +1,336 @@
+package passpersist
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// builtinMIB names the nodes most extensions are registered under, enough
+// to resolve base OIDs without any MIB files installed
+var builtinMIB = []struct {
+	module string
+	name   string
+	oid    string
+}{
+	{"SNMPv2-SMI", "iso", "1"},
+	{"SNMPv2-SMI", "org", "1.3"},
+	{"SNMPv2-SMI", "dod", "1.3.6"},
+	{"SNMPv2-SMI", "internet", "1.3.6.1"},
+	{"SNMPv2-SMI", "directory", "1.3.6.1.1"},
+	{"SNMPv2-SMI", "mgmt", "1.3.6.1.2"},
+	{"SNMPv2-SMI", "mib-2", "1.3.6.1.2.1"},
+	{"SNMPv2-SMI", "transmission", "1.3.6.1.2.1.10"},
+	{"SNMPv2-SMI", "experimental", "1.3.6.1.3"},
+	{"SNMPv2-SMI", "private", "1.3.6.1.4"},
+	{"SNMPv2-SMI", "enterprises", "1.3.6.1.4.1"},
+	{"SNMPv2-SMI", "security", "1.3.6.1.5"},
+	{"SNMPv2-SMI", "snmpV2", "1.3.6.1.6"},
+	{"SNMPv2-SMI", "snmpDomains", "1.3.6.1.6.1"},
+	{"SNMPv2-SMI", "snmpProxys", "1.3.6.1.6.2"},
+	{"SNMPv2-SMI", "snmpModules", "1.3.6.1.6.3"},
+	{"SNMPv2-MIB", "system", "1.3.6.1.2.1.1"},
+	{"SNMPv2-MIB", "sysDescr", "1.3.6.1.2.1.1.1"},
+	{"SNMPv2-MIB", "sysObjectID", "1.3.6.1.2.1.1.2"},
+	{"SNMPv2-MIB", "sysUpTime", "1.3.6.1.2.1.1.3"},
+	{"SNMPv2-MIB", "sysName", "1.3.6.1.2.1.1.5"},
+	{"SNMPv2-MIB", "snmpTrapOID", "1.3.6.1.6.3.1.1.4.1"},
+	{"IF-MIB", "interfaces", "1.3.6.1.2.1.2"},
+	{"IF-MIB", "ifMIB", "1.3.6.1.2.1.31"},
+	{"ARISTA-SMI-MIB", "arista", "1.3.6.1.4.1.30065"},
+	{"ARISTA-SMI-MIB", "aristaProducts", "1.3.6.1.4.1.30065.1"},
+	{"ARISTA-SMI-MIB", "aristaMibs", "1.3.6.1.4.1.30065.3"},
+	{"ARISTA-SMI-MIB", "aristaExperiment", "1.3.6.1.4.1.30065.4"},
+	{"NET-SNMP-MIB", "netSnmp", "1.3.6.1.4.1.8072"},
+	{"NET-SNMP-MIB", "netSnmpObjects", "1.3.6.1.4.1.8072.1"},
+	{"NET-SNMP-MIB", "netSnmpExamples", "1.3.6.1.4.1.8072.2"},
+	{"NET-SNMP-MIB", "netSnmpExperimental", "1.3.6.1.4.1.8072.9999"},
+	{"NET-SNMP-EXAMPLES-MIB", "netSnmpPassExamples", "1.3.6.1.4.1.8072.2.255"},
+	{"NET-SNMP-EXTEND-MIB", "nsExtendObjects", "1.3.6.1.4.1.8072.1.3.2"},
+}
+
+// DefaultMIBResolver is consulted by NewOID for names that are not dotted
+// numbers
+var DefaultMIBResolver = NewMIBResolver()
+
+type mibNode struct {
+	module string
+	name   string
+	oid    OID
+}
+
+// MIBResolver maps MIB object names to OIDs and back
+type MIBResolver struct {
+	mu      sync.RWMutex
+	byName  map[string][]*mibNode
+	byOID   map[string]*mibNode
+	pending []mibDef
+}
+
+func NewMIBResolver() *MIBResolver {
+	r := &MIBResolver{
+		byName: make(map[string][]*mibNode),
+		byOID:  make(map[string]*mibNode),
+	}
+	for _, b := range builtinMIB {
+		// not ParseOID, iso is a single arc
+		var subs []uint32
+		for _, f := range strings.Split(b.oid, ".") {
+			n, _ := strconv.ParseUint(f, 10, 32)
+			subs = append(subs, uint32(n))
+		}
+		r.add(b.module, b.name, OID{subs})
+	}
+	return r
+}
+
+func (r *MIBResolver) add(module string, name string, oid OID) {
+	n := &mibNode{module: module, name: name, oid: oid}
+	for _, m := range r.byName[name] {
+		if m.module == module {
+			*m = *n
+			return
+		}
+	}
+	r.byName[name] = append(r.byName[name], n)
+	if _, ok := r.byOID[oid.String()]; !ok {
+		r.byOID[oid.String()] = n
+	}
+}
+
+// Add registers name in module at oid, names added without a module are
+// shown unqualified
+func (r *MIBResolver) Add(module string, name string, oid OID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.add(module, name, oid)
+}
+
+func (r *MIBResolver) lookup(module string, name string) (*mibNode, error) {
+	nodes := r.byName[name]
+	if module != "" {
+		for _, n := range nodes {
+			if n.module == module {
+				return n, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown object %s::%s", module, name)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("unknown object %s", name)
+	}
+	return nodes[0], nil
+}
+
+// Resolve parses numeric OIDs as well as names such as
+// SNMPv2-SMI::experimental.226, experimental.226 and iso.org.dod.internet.3
+func (r *MIBResolver) Resolve(s string) (OID, error) {
+	if o, err := ParseOID(s); err == nil {
+		return o, nil
+	}
+
+	module, path, ok := strings.Cut(s, "::")
+	if !ok {
+		module, path = "", s
+	}
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return OID{}, &InvalidOIDErr{s, "no object name"}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var subs []uint32
+	for i, p := range strings.Split(path, ".") {
+		if n, err := strconv.ParseUint(p, 10, 32); err == nil {
+			subs = append(subs, uint32(n))
+			continue
+		}
+		node, err := r.lookup(module, p)
+		if i > 0 && err != nil {
+			node, err = r.lookup("", p)
+		}
+		if err != nil {
+			return OID{}, &InvalidOIDErr{s, err.Error()}
+		}
+		// names after the first must be children of what came before
+		if i > 0 && (len(node.oid.Value) != len(subs)+1 || !node.oid.Contains(OID{subs})) {
+			return OID{}, &InvalidOIDErr{s, fmt.Sprintf("%s is not below %s", p, OID{subs})}
+		}
+		subs = append(subs[:0:0], node.oid.Value...)
+	}
+
+	if len(subs) < 2 {
+		return OID{}, &InvalidOIDErr{s, "The first and second sub-identifier is required"}
+	}
+	if len(subs) > maxSubIDs {
+		return OID{}, &InvalidOIDErr{s, "The sub-identifiers in an OID is up to 128"}
+	}
+	return OID{subs}, nil
+}
+
+// Name returns the symbolic form of oid using the longest known prefix,
+// e.g. ARISTA-SMI-MIB::aristaExperiment.226.1, or the numeric form if no
+// prefix is known
+func (r *MIBResolver) Name(oid OID) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := len(oid.Value); i > 0; i-- {
+		n, ok := r.byOID[OID{oid.Value[:i]}.String()]
+		if !ok {
+			continue
+		}
+		name := n.name
+		if n.module != "" {
+			name = n.module + "::" + name
+		}
+		if i < len(oid.Value) {
+			name += "." + OID{oid.Value[i:]}.String()
+		}
+		return name
+	}
+	return oid.String()
+}
+
+// mibDef is an object definition waiting for its parent to be known
+type mibDef struct {
+	module string
+	name   string
+	parent string
+	subs   []uint32
+}
+
+var (
+	mibModuleRe = regexp.MustCompile(`([A-Z][A-Za-z0-9-]*)\s+DEFINITIONS\s*::=\s*BEGIN`)
+	mibDefRe    = regexp.MustCompile(`([a-z][A-Za-z0-9-]*)\s+(?:OBJECT\s+IDENTIFIER\s*|(?:OBJECT-TYPE|MODULE-IDENTITY|OBJECT-IDENTITY|NOTIFICATION-TYPE|OBJECT-GROUP|NOTIFICATION-GROUP|MODULE-COMPLIANCE|AGENT-CAPABILITIES)\b(?:[^:]|:[^:])*?)::=\s*\{([^}]*)\}`)
+	mibArcRe    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*\((\d+)\)$`)
+	mibCommentR = regexp.MustCompile(`--[^\n]*`)
+)
+
+// LoadFile reads the object definitions from a MIB module. Parents may be
+// defined in files loaded later.
+func (r *MIBResolver) LoadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	text := mibCommentR.ReplaceAllString(string(b), "")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// a file may hold several modules, definitions belong to the one before
+	mods := mibModuleRe.FindAllStringSubmatchIndex(text, -1)
+	module := func(pos int) string {
+		m := ""
+		for _, x := range mods {
+			if x[0] > pos {
+				break
+			}
+			m = text[x[2]:x[3]]
+		}
+		return m
+	}
+
+	for _, m := range mibDefRe.FindAllStringSubmatchIndex(text, -1) {
+		d := mibDef{module: module(m[0]), name: text[m[2]:m[3]]}
+		fields := strings.Fields(text[m[4]:m[5]])
+		if len(fields) < 2 {
+			continue
+		}
+		d.parent = fields[0]
+		if g := mibArcRe.FindStringSubmatch(d.parent); g != nil {
+			// { iso(1) org(3) ... } spells out the path from the root
+			d.parent = ""
+			fields = append([]string{g[1]}, fields[1:]...)
+		} else {
+			fields = fields[1:]
+		}
+		ok := true
+		for _, f := range fields {
+			if g := mibArcRe.FindStringSubmatch(f); g != nil {
+				f = g[1]
+			}
+			n, err := strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				ok = false
+				break
+			}
+			d.subs = append(d.subs, uint32(n))
+		}
+		if ok {
+			r.pending = append(r.pending, d)
+		}
+	}
+
+	r.resolvePending()
+	return nil
+}
+
+// resolvePending places every definition whose parent is known
+func (r *MIBResolver) resolvePending() {
+	for progress := true; progress; {
+		progress = false
+		rest := r.pending[:0]
+		for _, d := range r.pending {
+			var base []uint32
+			if d.parent != "" {
+				p, err := r.lookup(d.module, d.parent)
+				if err != nil {
+					p, err = r.lookup("", d.parent)
+				}
+				if err != nil {
+					rest = append(rest, d)
+					continue
+				}
+				base = p.oid.Value
+			}
+			subs := make([]uint32, 0, len(base)+len(d.subs))
+			subs = append(append(subs, base...), d.subs...)
+			r.add(d.module, d.name, OID{subs})
+			progress = true
+		}
+		r.pending = rest
+	}
+}
+
+// LoadDir loads every file in dir, files that are not MIB modules are
+// skipped
+func (r *MIBResolver) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err := r.LoadFile(filepath.Join(dir, e.Name())); err != nil {
+			slog.Warn("failed to load mib file", "file", e.Name(), slog.Any("error", err))
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, d := range r.pending {
+		slog.Debug("unresolved mib object", "module", d.module, "name", d.name, "parent", d.parent)
+	}
+	return nil
+}
+
+// WithMIBDir loads the MIB modules in dir into the DefaultMIBResolver
+func WithMIBDir(dir string) func(*PassPersist) {
+	return func(p *PassPersist) {
+		if err := DefaultMIBResolver.LoadDir(dir); err != nil {
+			slog.Warn("failed to load mib dir", "dir", dir, slog.Any("error", err))
+		}
+	}
+}
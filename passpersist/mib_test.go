@@ -0,0 +1,64 @@
+package passpersist
+
+import "testing"
+
+func TestMIBResolve(t *testing.T) {
+	r := NewMIBResolver()
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"1.3.6.1.4.1.30065.4.226", "1.3.6.1.4.1.30065.4.226"},
+		{"SNMPv2-SMI::experimental.226", "1.3.6.1.3.226"},
+		{"ARISTA-SMI-MIB::aristaExperiment.226", "1.3.6.1.4.1.30065.4.226"},
+		{"aristaExperiment.226.1", "1.3.6.1.4.1.30065.4.226.1"},
+		{"iso.org.dod.internet.private.enterprises.30065", "1.3.6.1.4.1.30065"},
+		{".iso.3.6.1.2.1", "1.3.6.1.2.1"},
+	}
+	for _, tt := range tests {
+		o, err := r.Resolve(tt.in)
+		if err != nil {
+			t.Errorf("%s: %s", tt.in, err)
+			continue
+		}
+		if o.String() != tt.want {
+			t.Errorf("%s resolved to %s, wanted %s", tt.in, o, tt.want)
+		}
+	}
+
+	for _, s := range []string{"nosuchObject.1", "IF-MIB::experimental", "iso.dod", "iso"} {
+		if o, err := r.Resolve(s); err == nil {
+			t.Errorf("expected an error resolving %s, got %s", s, o)
+		}
+	}
+
+	if n := r.Name(MustNewOID("1.3.6.1.4.1.30065.4.226.1")); n != "ARISTA-SMI-MIB::aristaExperiment.226.1" {
+		t.Errorf("unexpected name %s", n)
+	}
+	if n := r.Name(MustNewOID("2.5.4")); n != "2.5.4" {
+		t.Errorf("unexpected name %s", n)
+	}
+}
+
+func TestMIBLoadDir(t *testing.T) {
+	r := NewMIBResolver()
+	if err := r.LoadDir("testdata/mibs"); err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := r.Resolve("TEST-EXTENSION-MIB::testName.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1.3.6.1.4.1.30065.4.226.1.2.1.2.7"; o.String() != want {
+		t.Errorf("got %s, wanted %s", o, want)
+	}
+
+	if _, err := r.Resolve("testIgnored"); err == nil {
+		t.Error("commented out definitions must not be loaded")
+	}
+	if n := r.Name(o); n != "TEST-EXTENSION-MIB::testName.7" {
+		t.Errorf("unexpected name %s", n)
+	}
+}
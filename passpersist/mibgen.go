@@ -0,0 +1,292 @@
+package passpersist
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultMIBModule names the module written by DUMPMIB
+const DefaultMIBModule = "PASSPERSIST-MIB"
+
+// smiSyntax maps value types to SMIv2 syntax and the module defining it
+var smiSyntax = map[string][2]string{
+	"STRING":    {"DisplayString", "SNMPv2-TC"},
+	"INTEGER":   {"Integer32", "SNMPv2-SMI"},
+	"Counter32": {"Counter32", "SNMPv2-SMI"},
+	"Counter64": {"Counter64", "SNMPv2-SMI"},
+	"GAUGE":     {"Gauge32", "SNMPv2-SMI"},
+	"OCTET":     {"OCTET STRING", ""},
+	"IPADDRESS": {"IpAddress", "SNMPv2-SMI"},
+	"OBJECTID":  {"OBJECT IDENTIFIER", ""},
+	"TIMETICKS": {"TimeTicks", "SNMPv2-SMI"},
+}
+
+type mibTable struct {
+	oid OID
+	t   *Table
+}
+
+// tablesForMIB returns the named tables of p and its subtrees in OID order
+func (p *PassPersist) tablesForMIB() []mibTable {
+	var out []mibTable
+	for _, pp := range append([]*PassPersist{p}, p.subtreePPs()...) {
+		pp.tablesMu.Lock()
+		for _, t := range pp.tables {
+			out = append(out, mibTable{pp.baseOID.MustAppend(t.subs), t})
+		}
+		pp.tablesMu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].oid.Compare(out[j].oid) < 0 })
+	return out
+}
+
+// mibScalar is a scalar added with AddScalar or AddEnum, subs exclude the
+// .0 instance
+type mibScalar struct {
+	subs []int
+	typ  string
+	enum *Enum
+}
+
+func (p *PassPersist) addMIBScalar(subs []int, typ string, e *Enum) {
+	k := fmt.Sprint(subs)
+
+	p.tablesMu.Lock()
+	defer p.tablesMu.Unlock()
+
+	if s, ok := p.scalars[k]; ok && s.typ == typ && s.enum == e {
+		return
+	}
+	if p.scalars == nil {
+		p.scalars = make(map[string]mibScalar)
+	}
+	p.scalars[k] = mibScalar{append([]int(nil), subs...), typ, e}
+}
+
+type mibObject struct {
+	oid  OID
+	name string
+	s    mibScalar
+}
+
+// scalarsForMIB returns the scalars of p and its subtrees that have a name
+// in DefaultMIBResolver, in OID order
+func (p *PassPersist) scalarsForMIB() []mibObject {
+	var out []mibObject
+	for _, pp := range append([]*PassPersist{p}, p.subtreePPs()...) {
+		pp.tablesMu.Lock()
+		for _, s := range pp.scalars {
+			oid := pp.baseOID.MustAppend(s.subs)
+			// only an exact match names the object itself
+			name := DefaultMIBResolver.Name(oid)
+			if strings.ContainsAny(name, ".:") {
+				continue
+			}
+			out = append(out, mibObject{oid, name, s})
+		}
+		pp.tablesMu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].oid.Compare(out[j].oid) < 0 })
+	return out
+}
+
+func (p *PassPersist) subtreePPs() []*PassPersist {
+	var out []*PassPersist
+	for _, t := range p.subtrees {
+		if t.pp != nil {
+			out = append(out, t.pp)
+		}
+	}
+	return out
+}
+
+// mibNodeName derives the name of the module's root node, e.g.
+// PASSPERSIST-VRF-MIB gives passpersistVrfMIB
+func mibNodeName(module string) string {
+	var b strings.Builder
+	for i, w := range strings.Split(strings.TrimSuffix(module, "-MIB"), "-") {
+		if w == "" {
+			continue
+		}
+		w = strings.ToLower(w)
+		if i > 0 {
+			w = strings.ToUpper(w[:1]) + w[1:]
+		}
+		b.WriteString(w)
+	}
+	return b.String() + "MIB"
+}
+
+// WriteMIB writes a SMIv2 module describing the named scalars and tables
+// served by p and the enums they use. The MODULE-IDENTITY and the
+// descriptions are placeholders.
+func (p *PassPersist) WriteMIB(w io.Writer, module string) error {
+	imports := map[string]map[string]bool{}
+	use := func(mod string, name string) {
+		if mod == "" {
+			return
+		}
+		if imports[mod] == nil {
+			imports[mod] = map[string]bool{}
+		}
+		imports[mod][name] = true
+	}
+	use("SNMPv2-SMI", "MODULE-IDENTITY")
+	use("SNMPv2-SMI", "OBJECT-TYPE")
+
+	// the root node hangs off the closest known parent
+	root := mibNodeName(module)
+	parent := "iso " + OID{p.baseOID.Value[1:]}.String()
+	if n := DefaultMIBResolver.Name(p.baseOID); strings.Contains(n, "::") {
+		mod, rest, _ := strings.Cut(n, "::")
+		node, arcs, _ := strings.Cut(rest, ".")
+		parent = node
+		if arcs != "" {
+			parent += " " + arcs
+		}
+		if node != "iso" {
+			use(mod, node)
+		}
+	}
+	parent = strings.ReplaceAll(parent, ".", " ")
+
+	// SMIv2 requires a MODULE-IDENTITY, its text and every DESCRIPTION
+	// are placeholders to edit before publishing the module
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s MODULE-IDENTITY\n", root)
+	fmt.Fprintf(&body, "    LAST-UPDATED \"%s\"\n", time.Now().UTC().Format("200601021504Z"))
+	fmt.Fprintf(&body, "    ORGANIZATION \"placeholder\"\n")
+	fmt.Fprintf(&body, "    CONTACT-INFO \"placeholder\"\n")
+	fmt.Fprintf(&body, "    DESCRIPTION  \"Generated by DUMPMIB, descriptions are placeholders\"\n")
+	fmt.Fprintf(&body, "    ::= { %s }\n", parent)
+
+	scalars := p.scalarsForMIB()
+	tables := p.tablesForMIB()
+	enums := map[string]*Enum{}
+	for _, ms := range scalars {
+		if ms.s.enum != nil {
+			enums[ms.s.enum.Name] = ms.s.enum
+		}
+	}
+	for _, mt := range tables {
+		for _, c := range mt.t.columns {
+			if c.Enum != nil {
+				enums[c.Enum.Name] = c.Enum
+			}
+		}
+	}
+	names := make([]string, 0, len(enums))
+	for n := range enums {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		e := enums[n]
+		if e.module != "" {
+			use(e.module, e.Name)
+			continue
+		}
+		use("SNMPv2-TC", "TEXTUAL-CONVENTION")
+		fmt.Fprintf(&body, "\n%s ::= TEXTUAL-CONVENTION\n", e.Name)
+		fmt.Fprintf(&body, "    STATUS      current\n")
+		fmt.Fprintf(&body, "    DESCRIPTION \"%s\"\n", e.Name)
+		fmt.Fprintf(&body, "    SYNTAX      %s\n", e.Syntax())
+	}
+
+	for _, ms := range scalars {
+		rel := OID{ms.oid.Value[len(p.baseOID.Value):]}.String()
+		s, ok := smiSyntax[ms.s.typ]
+		if !ok {
+			s = smiSyntax["STRING"]
+		}
+		syntax := s[0]
+		if ms.s.enum != nil {
+			syntax = ms.s.enum.Name
+		} else {
+			use(s[1], s[0])
+		}
+		fmt.Fprintf(&body, "\n%s OBJECT-TYPE\n", ms.name)
+		fmt.Fprintf(&body, "    SYNTAX      %s\n", syntax)
+		fmt.Fprintf(&body, "    MAX-ACCESS  read-only\n")
+		fmt.Fprintf(&body, "    STATUS      current\n")
+		fmt.Fprintf(&body, "    DESCRIPTION \"%s\"\n", ms.name)
+		fmt.Fprintf(&body, "    ::= { %s %s }\n", root, strings.ReplaceAll(rel, ".", " "))
+	}
+
+	for _, mt := range tables {
+		t := mt.t
+		rel := OID{mt.oid.Value[len(p.baseOID.Value):]}.String()
+		entry := t.entryName()
+		entryType := strings.ToUpper(entry[:1]) + entry[1:]
+
+		fmt.Fprintf(&body, "\n%s OBJECT-TYPE\n", t.name)
+		fmt.Fprintf(&body, "    SYNTAX      SEQUENCE OF %s\n", entryType)
+		fmt.Fprintf(&body, "    MAX-ACCESS  not-accessible\n")
+		fmt.Fprintf(&body, "    STATUS      current\n")
+		fmt.Fprintf(&body, "    DESCRIPTION \"%s\"\n", t.name)
+		fmt.Fprintf(&body, "    ::= { %s %s }\n", root, strings.ReplaceAll(rel, ".", " "))
+
+		fmt.Fprintf(&body, "\n%s OBJECT-TYPE\n", entry)
+		fmt.Fprintf(&body, "    SYNTAX      %s\n", entryType)
+		fmt.Fprintf(&body, "    MAX-ACCESS  not-accessible\n")
+		fmt.Fprintf(&body, "    STATUS      current\n")
+		fmt.Fprintf(&body, "    DESCRIPTION \"%s\"\n", entry)
+		fmt.Fprintf(&body, "    INDEX       { %s }\n", strings.Join(t.indexNames(), ", "))
+		fmt.Fprintf(&body, "    ::= { %s 1 }\n", t.name)
+
+		syntax := make(map[int]string, len(t.order))
+		items := make([]string, 0, len(t.order))
+		for _, id := range t.order {
+			c := t.columns[id]
+			s, ok := smiSyntax[c.Type]
+			if !ok {
+				s = smiSyntax["STRING"]
+			}
+			syntax[id] = s[0]
+			if c.Enum != nil {
+				syntax[id] = c.Enum.Name
+			} else {
+				use(s[1], s[0])
+			}
+			items = append(items, fmt.Sprintf("    %s %s", c.Name, syntax[id]))
+		}
+		fmt.Fprintf(&body, "\n%s ::= SEQUENCE {\n%s\n}\n", entryType, strings.Join(items, ",\n"))
+
+		for _, id := range t.order {
+			c := t.columns[id]
+			fmt.Fprintf(&body, "\n%s OBJECT-TYPE\n", c.Name)
+			fmt.Fprintf(&body, "    SYNTAX      %s\n", syntax[id])
+			fmt.Fprintf(&body, "    MAX-ACCESS  read-only\n")
+			fmt.Fprintf(&body, "    STATUS      current\n")
+			fmt.Fprintf(&body, "    DESCRIPTION \"%s\"\n", c.Name)
+			fmt.Fprintf(&body, "    ::= { %s %d }\n", entry, id)
+		}
+	}
+
+	mods := make([]string, 0, len(imports))
+	for m := range imports {
+		mods = append(mods, m)
+	}
+	sort.Strings(mods)
+
+	var head strings.Builder
+	fmt.Fprintf(&head, "%s DEFINITIONS ::= BEGIN\n\nIMPORTS", module)
+	for i, m := range mods {
+		syms := make([]string, 0, len(imports[m]))
+		for s := range imports[m] {
+			syms = append(syms, s)
+		}
+		sort.Strings(syms)
+		fmt.Fprintf(&head, "\n    %s\n        FROM %s", strings.Join(syms, ", "), m)
+		if i == len(mods)-1 {
+			head.WriteString(";")
+		}
+	}
+	head.WriteString("\n\n")
+
+	_, err := fmt.Fprintf(w, "%s%s\nEND\n", head.String(), body.String())
+	return err
+}
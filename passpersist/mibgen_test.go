@@ -0,0 +1,112 @@
+package passpersist
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteMIB(t *testing.T) {
+	p := NewPassPersist(WithBaseOID(MustNewOID("1.3.6.1.3.53")), WithEnvOverride(false))
+
+	tbl, err := p.NewTable("portTable", []int{2},
+		Column{ID: 1, Type: "STRING", Name: "portName"},
+		Column{ID: 2, Name: "portStatus", Enum: LinkStatus},
+		Column{ID: 3, Type: "Counter64", Name: "portErrors"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := tbl.Row(IndexString("Ethernet1"))
+	if err := row.SetEnum(2, "errdisabled"); err != nil {
+		t.Fatal(err)
+	}
+	if err := row.SetEnum(1, "up"); err == nil {
+		t.Error("expected an error for a column without enum")
+	}
+
+	var b strings.Builder
+	if err := p.WriteMIB(&b, "TEST-PORT-MIB"); err != nil {
+		t.Fatal(err)
+	}
+	mib := b.String()
+	for _, want := range []string{
+		"TEST-PORT-MIB DEFINITIONS ::= BEGIN",
+		"Counter64, MODULE-IDENTITY, OBJECT-TYPE, experimental\n        FROM SNMPv2-SMI",
+		"testPortMIB MODULE-IDENTITY\n    LAST-UPDATED \"",
+		"    ::= { experimental 53 }",
+		"LinkStatus ::= TEXTUAL-CONVENTION",
+		"SYNTAX      INTEGER { unknown(0), connected(1), notconnect(2), disabled(3), errdisabled(4), inactive(5) }",
+		"INDEX       { portName }",
+		"portStatus LinkStatus,",
+	} {
+		if !strings.Contains(mib, want) {
+			t.Errorf("missing %q in\n%s", want, mib)
+		}
+	}
+
+	// the MIB loads back and names the same OIDs
+	path := filepath.Join(t.TempDir(), "TEST-PORT-MIB.txt")
+	if err := os.WriteFile(path, []byte(mib), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r := NewMIBResolver()
+	if err := r.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	o, err := r.Resolve("TEST-PORT-MIB::portErrors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1.3.6.1.3.53.2.1.3"; o.String() != want {
+		t.Errorf("got %s, wanted %s", o, want)
+	}
+}
+
+func TestWriteMIBScalars(t *testing.T) {
+	p := NewPassPersist(WithBaseOID(MustNewOID("1.3.6.1.3.54")), WithEnvOverride(false))
+	DefaultMIBResolver.Add("", "testPeerCount", p.BaseOID().MustAppend([]int{1}))
+	DefaultMIBResolver.Add("", "testPeerState", p.BaseOID().MustAppend([]int{2}))
+
+	p.AddScalar([]int{1}, &GaugeVal{Value: 2})
+	p.AddEnum([]int{2, 0}, "established", BGPPeerState)
+	// unnamed scalars are left out
+	p.AddScalar([]int{3}, &GaugeVal{Value: 3})
+
+	var b strings.Builder
+	if err := p.WriteMIB(&b, "TEST-PEER-MIB"); err != nil {
+		t.Fatal(err)
+	}
+	mib := b.String()
+	for _, want := range []string{
+		"Gauge32, MODULE-IDENTITY, OBJECT-TYPE, experimental\n        FROM SNMPv2-SMI",
+		"BgpPeerState ::= TEXTUAL-CONVENTION",
+		"testPeerCount OBJECT-TYPE\n    SYNTAX      Gauge32\n    MAX-ACCESS  read-only",
+		"testPeerState OBJECT-TYPE\n    SYNTAX      BgpPeerState\n",
+		"    ::= { testPeerMIB 2 }",
+	} {
+		if !strings.Contains(mib, want) {
+			t.Errorf("missing %q in\n%s", want, mib)
+		}
+	}
+	if strings.Contains(mib, "testPeerMIB 3 }") {
+		t.Errorf("unnamed scalar in\n%s", mib)
+	}
+
+	path := filepath.Join(t.TempDir(), "TEST-PEER-MIB.txt")
+	if err := os.WriteFile(path, []byte(mib), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r := NewMIBResolver()
+	if err := r.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	o, err := r.Resolve("TEST-PEER-MIB::testPeerState")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1.3.6.1.3.54.2"; o.String() != want {
+		t.Errorf("got %s, wanted %s", o, want)
+	}
+}
@@ -0,0 +1,420 @@
+package passpersist
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	sysUpTimeOID = MustNewOID("1.3.6.1.2.1.1.3.0")
+	snmpTrapOID  = MustNewOID("1.3.6.1.6.3.1.1.4.1.0")
+)
+
+var ErrRateLimited = errors.New("notification rate limited")
+
+// Notification is an SNMPv2 notification raised by an extension
+type Notification struct {
+	TrapOID  OID
+	VarBinds []*VarBind
+	// Uptime is reported as sysUpTime.0
+	Uptime time.Duration
+}
+
+// key identifies notifications carrying the same content
+func (n *Notification) key() string {
+	var b strings.Builder
+	b.WriteString(n.TrapOID.String())
+	for _, vb := range n.VarBinds {
+		b.WriteString("|")
+		b.WriteString(vb.Marshal())
+	}
+	return b.String()
+}
+
+// snmpVarBinds returns the varbind list of an SNMPv2-Trap-PDU (RFC 3416 Section 4.2.6)
+func (n *Notification) snmpVarBinds() ([]snmpVarBind, error) {
+	trap, err := n.TrapOID.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	vbs := []snmpVarBind{
+		{OID: sysUpTimeOID, Value: berUint(berTimeTicks, uint64(n.Uptime/(10*time.Millisecond)))},
+		{OID: snmpTrapOID, Value: trap},
+	}
+	for _, vb := range n.VarBinds {
+		v, err := vb.snmpVarBind()
+		if err != nil {
+			return nil, err
+		}
+		vbs = append(vbs, v)
+	}
+	return vbs, nil
+}
+
+type NotificationSender interface {
+	Send(ctx context.Context, n *Notification) error
+}
+
+// WithNotificationSender adds a sender used by SendNotification
+func WithNotificationSender(s NotificationSender) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.senders = append(p.senders, s)
+	}
+}
+
+// SendNotification emits trapOID with the given varbinds through every
+// configured sender, sends are abandoned once Run stops
+func (p *PassPersist) SendNotification(trapOID OID, varbinds ...*VarBind) error {
+	if len(p.senders) == 0 {
+		return errors.New("no notification senders configured")
+	}
+
+	n := &Notification{
+		TrapOID:  trapOID,
+		VarBinds: varbinds,
+		Uptime:   time.Since(p.start),
+	}
+
+	slog.Debug("sending notification", "trap-oid", trapOID.String(), "varbinds", len(varbinds))
+
+	var errs []error
+	for _, s := range p.senders {
+		if err := s.Send(p.Context(), n); err != nil {
+			// summarized by the rate limiter, logging each would flood
+			// syslog while a source flaps
+			if !errors.Is(err, ErrRateLimited) {
+				slog.Warn("failed to send notification", "trap-oid", trapOID.String(), slog.Any("error", err))
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type UDPSenderOption func(*UDPSender)
+
+func WithTrapCommunity(c string) UDPSenderOption {
+	return func(s *UDPSender) {
+		s.community = c
+	}
+}
+
+// WithInform sends acknowledged InformRequests instead of traps
+func WithInform(timeout time.Duration, retries int) UDPSenderOption {
+	return func(s *UDPSender) {
+		s.inform = true
+		s.timeout = timeout
+		s.retries = retries
+	}
+}
+
+// UDPSender sends SNMPv2c traps or informs to a single target
+type UDPSender struct {
+	addr      string
+	community string
+	inform    bool
+	timeout   time.Duration
+	retries   int
+
+	mu        sync.Mutex
+	requestID int32
+}
+
+func NewUDPSender(addr string, opts ...UDPSenderOption) *UDPSender {
+	s := &UDPSender{
+		addr:      addr,
+		community: "public",
+		timeout:   time.Second * 5,
+		retries:   2,
+	}
+
+	for _, fn := range opts {
+		fn(s)
+	}
+
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	s.requestID = int32(binary.BigEndian.Uint32(b[:]) & 0x7fffffff)
+
+	return s
+}
+
+func (s *UDPSender) nextRequestID() int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requestID = (s.requestID + 1) & 0x7fffffff
+	return s.requestID
+}
+
+func (s *UDPSender) Send(ctx context.Context, n *Notification) error {
+	vbs, err := n.snmpVarBinds()
+	if err != nil {
+		return err
+	}
+
+	pdu := &snmpPDU{
+		Type:      pduSNMPv2Trap,
+		RequestID: s.nextRequestID(),
+		VarBinds:  vbs,
+	}
+	if s.inform {
+		pdu.Type = pduInformRequest
+	}
+
+	b, err := pdu.marshal()
+	if err != nil {
+		return err
+	}
+	msg := berTLV(berSequence, berInt(berInteger, snmpV2c), berOctets([]byte(s.community)), b)
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if !s.inform {
+		_, err = conn.Write(msg)
+		return err
+	}
+
+	buf := make([]byte, 65535)
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+
+		deadline := time.Now().Add(s.timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+
+		for {
+			l, err := conn.Read(buf)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return err
+			}
+			if resp := parseInformResponse(buf[:l]); resp != nil && resp.RequestID == pdu.RequestID {
+				return nil
+			}
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.Debug("inform timed out, retrying", "addr", s.addr, "attempt", attempt+1)
+	}
+
+	return fmt.Errorf("no response to inform from %s", s.addr)
+}
+
+func parseInformResponse(b []byte) *snmpPDU {
+	body, _, err := berExpect(b, berSequence)
+	if err != nil {
+		return nil
+	}
+	if _, body, err = berReadInt(body); err != nil {
+		return nil
+	}
+	if _, body, err = berReadOctets(body); err != nil {
+		return nil
+	}
+	p, err := unmarshalPDU(body)
+	if err != nil || p.Type != pduResponse {
+		return nil
+	}
+	return p
+}
+
+// CommandSender hands notifications to the net-snmp snmptrap binary
+type CommandSender struct {
+	path string
+	args []string
+}
+
+// NewCommandSender runs snmptrap with args, which must include the version,
+// credentials and target, for example "-v", "2c", "-c", "public", "localhost"
+func NewCommandSender(args ...string) *CommandSender {
+	return &CommandSender{
+		path: "snmptrap",
+		args: args,
+	}
+}
+
+func (s *CommandSender) Send(ctx context.Context, n *Notification) error {
+	args, err := n.snmptrapArgs()
+	if err != nil {
+		return err
+	}
+	args = append(append([]string{}, s.args...), args...)
+
+	out, err := exec.CommandContext(ctx, s.path, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", s.path, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// snmptrapArgs returns the uptime, trap OID and typed varbinds in the order
+// expected by snmptrap
+func (n *Notification) snmptrapArgs() ([]string, error) {
+	args := []string{
+		strconv.FormatInt(int64(n.Uptime/(10*time.Millisecond)), 10),
+		n.TrapOID.String(),
+	}
+
+	for _, vb := range n.VarBinds {
+		var t, v string
+		switch x := vb.Value.GetValue().(type) {
+		case *StringVal:
+			t, v = "s", x.Value
+		case *IntVal:
+			t, v = "i", strconv.Itoa(int(x.Value))
+		case *Counter32Val:
+			t, v = "c", strconv.FormatUint(uint64(x.Value), 10)
+		case *Counter64Val:
+			t, v = "C", strconv.FormatUint(x.Value, 10)
+		case *GaugeVal:
+			t, v = "u", strconv.FormatUint(uint64(x.Value), 10)
+		case *OctetStringVal:
+			t, v = "x", hex.EncodeToString(x.Value)
+		case *IPAddrVal:
+			t, v = "a", x.Value.String()
+		case *IPV6AddrVal:
+			t, v = "s", x.Value.String()
+		case *OIDVal:
+			t, v = "o", x.Value.String()
+		case *TimeTicksVal:
+			t, v = "t", strconv.FormatInt(int64(x.Value/(10*time.Millisecond)), 10)
+		default:
+			return nil, fmt.Errorf("unsupported value type: %T", x)
+		}
+		args = append(args, vb.OID.String(), t, v)
+	}
+
+	return args, nil
+}
+
+type rateLimitedSender struct {
+	NotificationSender
+	mu     sync.Mutex
+	every  time.Duration
+	burst  int
+	tokens float64
+	last   time.Time
+	// limited counts the notifications dropped since the last one sent
+	limited int
+}
+
+// RateLimit allows bursts of up to burst notifications through s and one
+// more per interval after that
+func RateLimit(s NotificationSender, every time.Duration, burst int) NotificationSender {
+	return &rateLimitedSender{
+		NotificationSender: s,
+		every:              every,
+		burst:              burst,
+		tokens:             float64(burst),
+		last:               time.Now(),
+	}
+}
+
+// allow takes a token, it also returns the notifications dropped since
+// the last one allowed
+func (s *rateLimitedSender) allow() (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.every > 0 {
+		s.tokens += float64(now.Sub(s.last)) / float64(s.every)
+	}
+	if s.tokens > float64(s.burst) {
+		s.tokens = float64(s.burst)
+	}
+	s.last = now
+
+	if s.tokens < 1 {
+		s.limited++
+		return false, 0
+	}
+	s.tokens--
+	limited := s.limited
+	s.limited = 0
+	return true, limited
+}
+
+// Send drops n when over the rate. The drops are logged once, with the
+// next notification allowed, so at most once per interval.
+func (s *rateLimitedSender) Send(ctx context.Context, n *Notification) error {
+	ok, limited := s.allow()
+	if !ok {
+		return ErrRateLimited
+	}
+	if limited > 0 {
+		slog.Warn("notifications rate limited", "dropped", limited)
+	}
+	return s.NotificationSender.Send(ctx, n)
+}
+
+type dedupSender struct {
+	NotificationSender
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[string]time.Time
+}
+
+// Dedup drops notifications identical to one already sent through s
+// within window. It wraps RateLimit, not the other way round, so
+// duplicates do not use up the burst.
+func Dedup(s NotificationSender, window time.Duration) NotificationSender {
+	return &dedupSender{
+		NotificationSender: s,
+		window:             window,
+		seen:               make(map[string]time.Time),
+	}
+}
+
+func (s *dedupSender) Send(ctx context.Context, n *Notification) error {
+	k := n.key()
+	now := time.Now()
+
+	s.mu.Lock()
+	for key, t := range s.seen {
+		if now.Sub(t) >= s.window {
+			delete(s.seen, key)
+		}
+	}
+	if _, ok := s.seen[k]; ok {
+		s.mu.Unlock()
+		slog.Debug("dropping duplicate notification", "trap-oid", n.TrapOID.String())
+		return nil
+	}
+	s.seen[k] = now
+	s.mu.Unlock()
+
+	err := s.NotificationSender.Send(ctx, n)
+	if err != nil {
+		// allow a retry of failed notifications
+		s.mu.Lock()
+		delete(s.seen, k)
+		s.mu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,212 @@
+package passpersist
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultAgentXAddr = "unix:/var/agentx/master"
+
+// AgentX PDU types and flags (RFC 2741 Section 6.1)
+const (
+	agentxOpen     byte = 1
+	agentxClose    byte = 2
+	agentxNotify   byte = 12
+	agentxResponse byte = 18
+
+	agentxFlagNetworkByteOrder byte = 0x10
+
+	agentxCloseShutdown byte = 5
+)
+
+// AgentX varbind types (RFC 2741 Section 5.4)
+const (
+	agentxInteger     uint16 = 2
+	agentxOctetString uint16 = 4
+	agentxObjectID    uint16 = 6
+	agentxIPAddress   uint16 = 64
+	agentxCounter32   uint16 = 65
+	agentxGauge32     uint16 = 66
+	agentxTimeTicks   uint16 = 67
+	agentxCounter64   uint16 = 70
+)
+
+// AgentXSender emits notifications as an AgentX subagent of the master agent
+type AgentXSender struct {
+	network string
+	addr    string
+	timeout time.Duration
+	id      OID
+
+	mu       sync.Mutex
+	packetID uint32
+}
+
+// NewAgentXSender connects to addr, either "unix:/path" or "tcp:host:port"
+func NewAgentXSender(addr string) *AgentXSender {
+	network, a, ok := strings.Cut(addr, ":")
+	if !ok || (network != "unix" && network != "tcp") {
+		network, a = "unix", addr
+	}
+	return &AgentXSender{
+		network: network,
+		addr:    a,
+		timeout: time.Second * 5,
+		id:      MustNewOID(AristaExperimentalMib),
+	}
+}
+
+func (s *AgentXSender) Send(ctx context.Context, n *Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, s.network, s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+
+	open := []byte{byte(s.timeout / time.Second), 0, 0, 0}
+	open = append(open, agentxOID(s.id)...)
+	open = append(open, agentxOctets([]byte("passpersist"))...)
+	session, err := s.request(conn, agentxOpen, 0, open)
+	if err != nil {
+		return fmt.Errorf("agentx open failed: %w", err)
+	}
+
+	payload, err := n.agentxVarBinds()
+	if err != nil {
+		return err
+	}
+	_, err = s.request(conn, agentxNotify, session, payload)
+
+	// always try to close the session
+	if _, cerr := s.request(conn, agentxClose, session, []byte{agentxCloseShutdown, 0, 0, 0}); err == nil && cerr != nil {
+		err = fmt.Errorf("agentx close failed: %w", cerr)
+	}
+
+	return err
+}
+
+// request writes a PDU and waits for the matching response, returning the
+// session ID from its header
+func (s *AgentXSender) request(conn net.Conn, typ byte, session uint32, payload []byte) (uint32, error) {
+	s.packetID++
+
+	h := make([]byte, 20, 20+len(payload))
+	h[0] = 1
+	h[1] = typ
+	h[2] = agentxFlagNetworkByteOrder
+	binary.BigEndian.PutUint32(h[4:], session)
+	binary.BigEndian.PutUint32(h[12:], s.packetID)
+	binary.BigEndian.PutUint32(h[16:], uint32(len(payload)))
+
+	if _, err := conn.Write(append(h, payload...)); err != nil {
+		return 0, err
+	}
+
+	for {
+		resp := make([]byte, 20)
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return 0, err
+		}
+		body := make([]byte, binary.BigEndian.Uint32(resp[16:]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return 0, err
+		}
+
+		if resp[1] != agentxResponse || binary.BigEndian.Uint32(resp[12:]) != s.packetID {
+			continue
+		}
+		if len(body) < 8 {
+			return 0, errors.New("short agentx response")
+		}
+		if e := binary.BigEndian.Uint16(body[4:]); e != 0 {
+			return 0, fmt.Errorf("agentx error %d", e)
+		}
+		return binary.BigEndian.Uint32(resp[4:]), nil
+	}
+}
+
+func agentxOID(o OID) []byte {
+	b := make([]byte, 4, 4+4*len(o.Value))
+	b[0] = byte(len(o.Value))
+	for _, i := range o.Value {
+		b = binary.BigEndian.AppendUint32(b, uint32(i))
+	}
+	return b
+}
+
+func agentxOctets(v []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(v)))
+	b = append(b, v...)
+	if r := len(v) % 4; r != 0 {
+		b = append(b, make([]byte, 4-r)...)
+	}
+	return b
+}
+
+func agentxVarBind(typ uint16, o OID, data []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, typ)
+	b = append(b, 0, 0)
+	b = append(b, agentxOID(o)...)
+	return append(b, data...)
+}
+
+// agentxVarBinds encodes the payload of a Notify PDU (RFC 2741 Section 6.2.10)
+func (n *Notification) agentxVarBinds() ([]byte, error) {
+	b := agentxVarBind(agentxTimeTicks, sysUpTimeOID,
+		binary.BigEndian.AppendUint32(nil, uint32(n.Uptime/(10*time.Millisecond))))
+	b = append(b, agentxVarBind(agentxObjectID, snmpTrapOID, agentxOID(n.TrapOID))...)
+
+	for _, vb := range n.VarBinds {
+		var typ uint16
+		var data []byte
+		switch x := vb.Value.GetValue().(type) {
+		case *StringVal:
+			typ, data = agentxOctetString, agentxOctets([]byte(x.Value))
+		case *IntVal:
+			typ, data = agentxInteger, binary.BigEndian.AppendUint32(nil, uint32(x.Value))
+		case *Counter32Val:
+			typ, data = agentxCounter32, binary.BigEndian.AppendUint32(nil, x.Value)
+		case *Counter64Val:
+			typ, data = agentxCounter64, binary.BigEndian.AppendUint64(nil, x.Value)
+		case *GaugeVal:
+			typ, data = agentxGauge32, binary.BigEndian.AppendUint32(nil, x.Value)
+		case *OctetStringVal:
+			typ, data = agentxOctetString, agentxOctets(x.Value)
+		case *IPAddrVal:
+			a := x.Value.Unmap()
+			if !a.Is4() {
+				return nil, fmt.Errorf("not an IPv4 address: %s", x.Value)
+			}
+			ip := a.As4()
+			typ, data = agentxIPAddress, agentxOctets(ip[:])
+		case *IPV6AddrVal:
+			typ, data = agentxOctetString, agentxOctets([]byte(x.Value.String()))
+		case *OIDVal:
+			typ, data = agentxObjectID, agentxOID(x.Value)
+		case *TimeTicksVal:
+			typ, data = agentxTimeTicks, binary.BigEndian.AppendUint32(nil, uint32(x.Value/(10*time.Millisecond)))
+		default:
+			return nil, fmt.Errorf("unsupported value type: %T", x)
+		}
+		b = append(b, agentxVarBind(typ, vb.OID, data)...)
+	}
+
+	return b, nil
+}
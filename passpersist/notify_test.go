@@ -0,0 +1,204 @@
+package passpersist
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type countingSender struct {
+	sent []*Notification
+}
+
+func (s *countingSender) Send(ctx context.Context, n *Notification) error {
+	s.sent = append(s.sent, n)
+	return nil
+}
+
+func testNotification() *Notification {
+	return &Notification{
+		TrapOID:  MustNewOID("1.3.6.1.4.1.30065.4.226.0.1"),
+		VarBinds: []*VarBind{NewVarBind(MustNewOID("1.3.6.1.4.1.30065.4.226.10.2"), &StringVal{"down"})},
+		Uptime:   time.Second * 3,
+	}
+}
+
+func TestUDPSender(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, inform := range []bool{false, true} {
+		var opts []UDPSenderOption
+		if inform {
+			opts = append(opts, WithInform(time.Second, 1))
+		}
+		s := NewUDPSender(conn.LocalAddr().String(), opts...)
+
+		errc := make(chan error, 1)
+		go func() {
+			errc <- s.Send(context.Background(), testNotification())
+		}()
+
+		buf := make([]byte, 65535)
+		conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _, _ := berExpect(buf[:n], berSequence)
+		_, body, _ = berReadInt(body)
+		community, body, _ := berReadOctets(body)
+		pdu, err := unmarshalPDU(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := pduSNMPv2Trap
+		if inform {
+			want = pduInformRequest
+		}
+		if string(community) != "public" || pdu.Type != want {
+			t.Errorf("unexpected pdu type 0x%02x", pdu.Type)
+		}
+		if len(pdu.VarBinds) != 3 || !pdu.VarBinds[0].OID.Equal(sysUpTimeOID) || !pdu.VarBinds[1].OID.Equal(snmpTrapOID) {
+			t.Errorf("unexpected varbinds %+v", pdu.VarBinds)
+		}
+
+		if inform {
+			pdu.Type = pduResponse
+			b, _ := pdu.marshal()
+			conn.WriteTo(berTLV(berSequence, berInt(berInteger, snmpV2c), berOctets(community), b), addr)
+		}
+
+		if err := <-errc; err != nil {
+			t.Errorf("send failed: %s", err)
+		}
+	}
+}
+
+func TestDedupAndRateLimit(t *testing.T) {
+	c := &countingSender{}
+	s := Dedup(c, time.Minute)
+	for i := 0; i < 3; i++ {
+		s.Send(context.Background(), testNotification())
+	}
+	if len(c.sent) != 1 {
+		t.Errorf("expected 1 notification after dedup, got %d", len(c.sent))
+	}
+
+	c = &countingSender{}
+	s = RateLimit(c, time.Hour, 2)
+	var limited int
+	for i := 0; i < 5; i++ {
+		if err := s.Send(context.Background(), testNotification()); errors.Is(err, ErrRateLimited) {
+			limited++
+		}
+	}
+	if len(c.sent) != 2 || limited != 3 {
+		t.Errorf("expected 2 sent and 3 limited, got %d and %d", len(c.sent), limited)
+	}
+
+	// duplicates are dropped before they reach the rate limit
+	c = &countingSender{}
+	s = Dedup(RateLimit(c, time.Hour, 2), time.Minute)
+	for i := 0; i < 3; i++ {
+		s.Send(context.Background(), testNotification())
+	}
+	n := testNotification()
+	n.TrapOID = MustNewOID("1.3.6.1.4.1.8072.2.3.0.2")
+	if err := s.Send(context.Background(), n); err != nil || len(c.sent) != 2 {
+		t.Errorf("expected 2 sent, got %d: %v", len(c.sent), err)
+	}
+}
+
+func TestRateLimitedLogging(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var log strings.Builder
+	slog.SetDefault(slog.New(slog.NewTextHandler(&log, nil)))
+
+	rl := RateLimit(&countingSender{}, 50*time.Millisecond, 1).(*rateLimitedSender)
+	p := NewPassPersist(WithNotificationSender(rl))
+	for i := 0; i < 5; i++ {
+		if err := p.SendNotification(testNotification().TrapOID); i > 0 && !errors.Is(err, ErrRateLimited) {
+			t.Errorf("%d: got %v", i, err)
+		}
+	}
+	if strings.Contains(log.String(), "failed to send") {
+		t.Errorf("rate limited sends logged one by one:\n%s", log.String())
+	}
+
+	// summarized once the next notification goes through
+	time.Sleep(60 * time.Millisecond)
+	p.SendNotification(testNotification().TrapOID)
+	if n := strings.Count(log.String(), "notifications rate limited"); n != 1 || !strings.Contains(log.String(), "dropped=4") {
+		t.Errorf("expected one summary of 4 drops:\n%s", log.String())
+	}
+}
+
+func TestSnmptrapArgs(t *testing.T) {
+	args, err := testNotification().snmptrapArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "300 1.3.6.1.4.1.30065.4.226.0.1 1.3.6.1.4.1.30065.4.226.10.2 s down"
+	if got := strings.Join(args, " "); got != want {
+		t.Errorf("got '%s', wanted '%s'", got, want)
+	}
+}
+
+func TestAgentXSender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	types := make(chan byte, 3)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 0; i < 3; i++ {
+			h := make([]byte, 20)
+			if _, err := io.ReadFull(conn, h); err != nil {
+				return
+			}
+			body := make([]byte, binary.BigEndian.Uint32(h[16:]))
+			io.ReadFull(conn, body)
+			types <- h[1]
+
+			resp := make([]byte, 28)
+			resp[0] = 1
+			resp[1] = agentxResponse
+			binary.BigEndian.PutUint32(resp[4:], 99)
+			copy(resp[12:16], h[12:16])
+			binary.BigEndian.PutUint32(resp[16:], 8)
+			conn.Write(resp)
+		}
+	}()
+
+	s := NewAgentXSender("unix:" + path)
+	if err := s.Send(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []byte{agentxOpen, agentxNotify, agentxClose} {
+		if got := <-types; got != want {
+			t.Errorf("got pdu type %d, wanted %d", got, want)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package passpersist
 // taken from: https://github.com/k-sone/snmpgo/blob/master/variables.go
 
 import (
-	"bytes"
 	"encoding/asn1"
 	"encoding/json"
 	"fmt"
@@ -12,7 +11,6 @@ import (
 	"os"
 	"sort"
 	"strconv"
-	"strings"
 )
 
 type InvalidOIDErr struct {
@@ -24,8 +22,9 @@ func (e *InvalidOIDErr) Error() string {
 	return fmt.Sprintf("Invalid OID '%s': %s", e.Value, e.Message)
 }
 
+// OID holds the sub-identifiers of an object identifier
 type OID struct {
-	Value asn1.ObjectIdentifier
+	Value []uint32
 }
 
 func (o *OID) EnvDecode(value string) error {
@@ -34,7 +33,14 @@ func (o *OID) EnvDecode(value string) error {
 }
 
 func (v OID) String() string {
-	return v.Value.String()
+	b := make([]byte, 0, len(v.Value)*4)
+	for i, s := range v.Value {
+		if i > 0 {
+			b = append(b, '.')
+		}
+		b = strconv.AppendUint(b, uint64(s), 10)
+	}
+	return string(b)
 }
 
 func (v OID) Type() string {
@@ -42,7 +48,11 @@ func (v OID) Type() string {
 }
 
 func (v OID) Marshal() ([]byte, error) {
-	return asn1.Marshal(v.Value)
+	id := make(asn1.ObjectIdentifier, len(v.Value))
+	for i, s := range v.Value {
+		id[i] = int(s)
+	}
+	return asn1.Marshal(id)
 }
 
 func (v OID) MarshalJSON() ([]byte, error) {
@@ -73,46 +83,64 @@ func (v OID) Contains(o OID) bool {
 
 // Returns 0 this OID is equal to the specified OID,
 // -1 this OID is lexicographically less than the specified OID,
-// 1 this OID is lexicographically greater than the specified OID
+// 1 this OID is lexicographically greater than the specified OID.
+// A strict prefix sorts before every OID it contains.
 func (v OID) Compare(o OID) int {
-	vl := len(v.Value)
-	ol := len(o.Value)
+	n := len(v.Value)
+	if len(o.Value) < n {
+		n = len(o.Value)
+	}
 
-	for i := 0; i < vl; i++ {
-		if ol <= i || v.Value[i] > o.Value[i] {
-			return 1
-		} else if v.Value[i] < o.Value[i] {
+	for i := 0; i < n; i++ {
+		if v.Value[i] < o.Value[i] {
 			return -1
+		} else if v.Value[i] > o.Value[i] {
+			return 1
 		}
 	}
 
-	if ol == vl {
-		return 0
+	switch {
+	case len(v.Value) < len(o.Value):
+		return -1
+	case len(v.Value) > len(o.Value):
+		return 1
 	}
-
-	return -1
+	return 0
 }
 
 // Returns true if this OID is same the specified OID
 func (v OID) Equal(o OID) bool {
-	return v.Value.Equal(o.Value)
+	if len(v.Value) != len(o.Value) {
+		return false
+	}
+	for i, s := range v.Value {
+		if o.Value[i] != s {
+			return false
+		}
+	}
+	return true
 }
 
 func (v OID) StartsWith(o OID) bool {
-	if len(v.Value) >= len(o.Value) {
-		return v.Value[:len(o.Value)].Equal(o.Value)
-	}
-	return false
+	return v.Contains(o)
 }
 
 // Returns OID with additional sub-ids
 func (v OID) Append(subs []int) (OID, error) {
-	buf := bytes.NewBufferString(v.String())
-	for _, i := range subs {
-		buf.WriteString(".")
-		buf.WriteString(strconv.Itoa(i))
+	if len(v.Value)+len(subs) > maxSubIDs {
+		return OID{}, &InvalidOIDErr{v.String(), "The sub-identifiers in an OID is up to 128"}
+	}
+
+	o := make([]uint32, len(v.Value), len(v.Value)+len(subs))
+	copy(o, v.Value)
+	for _, s := range subs {
+		if s < 0 || int64(s) > math.MaxUint32 {
+			return OID{}, &InvalidOIDErr{v.String(), fmt.Sprintf("The sub-identifiers is range %d..%d", 0, int64(math.MaxUint32))}
+		}
+		o = append(o, uint32(s))
 	}
-	return NewOID(buf.String())
+
+	return OID{o}, nil
 }
 
 func (v OID) MustAppend(subs []int) OID {
@@ -124,33 +152,79 @@ func (v OID) MustAppend(subs []int) OID {
 	return o
 }
 
+// RFC2578 Section 3.5
+const maxSubIDs = 128
+
+// NewOID parses a dotted numeric OID, anything else is looked up by name
+// in the DefaultMIBResolver
 func NewOID(s string) (oid OID, err error) {
-	subids := strings.Split(s, ".")
+	oid, err = ParseOID(s)
+	if err != nil && isSymbolicOID(s) {
+		return DefaultMIBResolver.Resolve(s)
+	}
+	return oid, err
+}
 
-	// if there is a leadong dot, the first element will be empty
-	if subids[0] == "" {
-		subids = subids[1:]
+func isSymbolicOID(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseOID parses a dotted numeric OID, with or without a leading dot, in a
+// single pass
+func ParseOID(s string) (OID, error) {
+	t := s
+	// a leading dot is allowed
+	if len(t) > 0 && t[0] == '.' {
+		t = t[1:]
+	}
+
+	n := 1
+	for i := 0; i < len(t); i++ {
+		if t[i] == '.' {
+			n++
+		}
 	}
 
 	// ISO/IEC 8825 Section 8.19.4
-	if len(subids) < 2 {
+	if n < 2 {
 		return OID{}, &InvalidOIDErr{s, "The first and second sub-identifier is required"}
 	}
 
-	// RFC2578 Section 3.5
-	if len(subids) > 128 {
+	if n > maxSubIDs {
 		return OID{}, &InvalidOIDErr{s, "The sub-identifiers in an OID is up to 128"}
 	}
 
-	o := make(asn1.ObjectIdentifier, len(subids))
-	for i, v := range subids {
-		o[i], err = strconv.Atoi(v)
-		if err != nil || o[i] < 0 || int64(o[i]) > math.MaxUint32 {
+	o := make([]uint32, 0, n)
+	var cur uint64
+	digits := 0
+	for i := 0; i <= len(t); i++ {
+		if i == len(t) || t[i] == '.' {
+			if digits == 0 {
+				return OID{}, &InvalidOIDErr{s, fmt.Sprintf("The sub-identifiers is range %d..%d", 0, int64(math.MaxUint32))}
+			}
+			o = append(o, uint32(cur))
+			cur, digits = 0, 0
+			continue
+		}
+
+		c := t[i]
+		if c < '0' || c > '9' {
+			return OID{}, &InvalidOIDErr{s, fmt.Sprintf("The sub-identifiers is range %d..%d", 0, int64(math.MaxUint32))}
+		}
+		cur = cur*10 + uint64(c-'0')
+		digits++
+		if cur > math.MaxUint32 {
 			return OID{}, &InvalidOIDErr{s, fmt.Sprintf("The sub-identifiers is range %d..%d", 0, int64(math.MaxUint32))}
 		}
 	}
 
-	if len(o) > 0 && o[0] > 2 {
+	if o[0] > 2 {
 		return OID{}, &InvalidOIDErr{s, "The first sub-identifier is range 0..2"}
 	}
 
@@ -221,7 +295,7 @@ func (o sortableOIDs) Swap(i, j int) {
 }
 
 func (o sortableOIDs) Less(i, j int) bool {
-	return o.OIDs[i].Compare(o.OIDs[j]) < 1
+	return o.OIDs[i].Compare(o.OIDs[j]) < 0
 }
 
 func NewOIDs(s []string) (oids OIDs, err error) {
@@ -2,6 +2,7 @@ package passpersist
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -24,3 +25,133 @@ func TestOIDAppend(t *testing.T) {
 
 	fmt.Println(o.String())
 }
+
+func TestParseOID(t *testing.T) {
+	for _, s := range []string{"1.3.6.1.4.1.30065", ".1.3.6.1", "0.0", "2.999.4294967295"} {
+		o, err := ParseOID(s)
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+		if want := strings.TrimPrefix(s, "."); o.String() != want {
+			t.Errorf("got %s, wanted %s", o, want)
+		}
+	}
+
+	for _, s := range []string{"", "1", ".", "1..3", "1.3.", "1.x.3", "3.1", "1.40", "1.3.4294967296"} {
+		if o, err := ParseOID(s); err == nil {
+			t.Errorf("expected an error parsing %q, got %s", s, o)
+		}
+	}
+}
+
+func TestOIDAppendRange(t *testing.T) {
+	o := MustNewOID("1.3")
+	if _, err := o.Append([]int{-1}); err == nil {
+		t.Error("expected an error appending a negative sub-identifier")
+	}
+	if _, err := o.Append(make([]int, maxSubIDs)); err == nil {
+		t.Error("expected an error appending past the sub-identifier limit")
+	}
+
+	// appending must not share the backing array with the original
+	a := o.MustAppend([]int{1})
+	b := o.MustAppend([]int{2})
+	if a.String() != "1.3.1" || b.String() != "1.3.2" {
+		t.Errorf("unexpected results %s and %s", a, b)
+	}
+}
+
+func BenchmarkParseOID(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseOID("1.3.6.1.4.1.30065.4.226.1.2.3"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkOIDAppend(b *testing.B) {
+	o := MustNewOID("1.3.6.1.4.1.30065.4.226")
+	subs := []int{1, 2, 3}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := o.Append(subs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestOIDCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.3.6", "1.3.6", 0},
+		{"1.3.6", "1.3.7", -1},
+		{"1.3.7", "1.3.6", 1},
+		{"1.3.6", "1.3.6.1", -1},
+		{"1.3.6.1", "1.3.6", 1},
+		{"1.3.6.1", "1.3.7", -1},
+		{"1.3.10", "1.3.9.1", 1},
+	}
+	for _, tt := range tests {
+		if got := MustNewOID(tt.a).Compare(MustNewOID(tt.b)); got != tt.want {
+			t.Errorf("%s compared to %s is %d, wanted %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	sorted := OIDs{MustNewOID("1.3.6.1"), MustNewOID("1.3.6"), MustNewOID("1.3.6"), MustNewOID("1.3.5.9")}.Sort()
+	want := []string{"1.3.5.9", "1.3.6", "1.3.6", "1.3.6.1"}
+	for i, o := range sorted {
+		if o.String() != want[i] {
+			t.Fatalf("unexpected order %v", sorted)
+		}
+	}
+}
+
+// fuzzOID builds an OID from raw bytes, small sub-ids make shared prefixes
+// likely
+func fuzzOID(b []byte) OID {
+	o := make([]uint32, 0, len(b))
+	for _, c := range b {
+		o = append(o, uint32(c%4))
+	}
+	return OID{o}
+}
+
+func FuzzOIDCompare(f *testing.F) {
+	f.Add([]byte{1, 3, 6}, []byte{1, 3, 6, 1}, []byte{1, 3, 7})
+	f.Add([]byte{}, []byte{0}, []byte{0, 0})
+	f.Add([]byte{2, 1}, []byte{2, 1}, []byte{1, 2})
+
+	f.Fuzz(func(t *testing.T, x, y, z []byte) {
+		a, b, c := fuzzOID(x), fuzzOID(y), fuzzOID(z)
+
+		ab, ba := a.Compare(b), b.Compare(a)
+		if ab != -ba {
+			t.Fatalf("not antisymmetric: %s cmp %s = %d, reversed %d", a, b, ab, ba)
+		}
+		if (ab == 0) != a.Equal(b) {
+			t.Fatalf("compare %d disagrees with Equal for %s and %s", ab, a, b)
+		}
+		if a.Compare(a) != 0 {
+			t.Fatalf("%s does not compare equal to itself", a)
+		}
+
+		if ab <= 0 && b.Compare(c) <= 0 && a.Compare(c) > 0 {
+			t.Fatalf("not transitive: %s <= %s <= %s but %s > %s", a, b, c, a, c)
+		}
+
+		// everything under a prefix sorts at or after it
+		if a.Contains(b) && ab < 0 {
+			t.Fatalf("%s contains %s but sorts before it", a, b)
+		}
+		if a.StartsWith(b) != a.Contains(b) {
+			t.Fatalf("StartsWith and Contains disagree for %s and %s", a, b)
+		}
+		if a.Contains(b) && !a.Equal(b) && ab != 1 {
+			t.Fatalf("%s is under %s but compares %d", a, b, ab)
+		}
+	})
+}
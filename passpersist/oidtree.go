@@ -0,0 +1,280 @@
+package passpersist
+
+import "sort"
+
+// oidTree holds VarBinds in a trie keyed by sub-identifiers. Children are
+// kept sorted so a pre-order traversal yields SNMP lexicographic order.
+type oidTree struct {
+	root oidNode
+	size int
+}
+
+type oidNode struct {
+	sub      uint32
+	vb       *VarBind
+	children []*oidNode
+}
+
+func newOIDTree() *oidTree {
+	return &oidTree{}
+}
+
+// child returns the position of sub in children, or where it would be inserted
+func (n *oidNode) child(sub uint32) (int, bool) {
+	i := sort.Search(len(n.children), func(i int) bool {
+		return n.children[i].sub >= sub
+	})
+	return i, i < len(n.children) && n.children[i].sub == sub
+}
+
+func (t *oidTree) find(subs []uint32) *oidNode {
+	n := &t.root
+	for _, s := range subs {
+		i, ok := n.child(s)
+		if !ok {
+			return nil
+		}
+		n = n.children[i]
+	}
+	return n
+}
+
+func (t *oidTree) get(oid OID) *VarBind {
+	if n := t.find(oid.Value); n != nil {
+		return n.vb
+	}
+	return nil
+}
+
+// set stores vb and returns the VarBind it replaced, if any
+func (t *oidTree) set(vb *VarBind) *VarBind {
+	n := &t.root
+	for _, s := range vb.OID.Value {
+		i, ok := n.child(s)
+		if !ok {
+			c := &oidNode{sub: s}
+			n.children = append(n.children, nil)
+			copy(n.children[i+1:], n.children[i:])
+			n.children[i] = c
+		}
+		n = n.children[i]
+	}
+
+	old := n.vb
+	if old == nil {
+		t.size++
+	}
+	n.vb = vb
+	return old
+}
+
+// conflict returns the VarBind that oid would overwrite, or that is a
+// leaf above oid or below it
+func (t *oidTree) conflict(oid OID) *VarBind {
+	n := &t.root
+	for _, s := range oid.Value {
+		if n.vb != nil {
+			return n.vb
+		}
+		i, ok := n.child(s)
+		if !ok {
+			return nil
+		}
+		n = n.children[i]
+	}
+	return n.first()
+}
+
+func (n *oidNode) count() int {
+	c := 0
+	if n.vb != nil {
+		c++
+	}
+	for _, ch := range n.children {
+		c += ch.count()
+	}
+	return c
+}
+
+// detach removes and returns the node at prefix with everything below it,
+// empty parents are pruned
+func (t *oidTree) detach(prefix OID) *oidNode {
+	subs := prefix.Value
+	if len(subs) == 0 {
+		return nil
+	}
+
+	path := make([]*oidNode, 0, len(subs))
+	n := &t.root
+	for _, s := range subs {
+		i, ok := n.child(s)
+		if !ok {
+			return nil
+		}
+		path = append(path, n)
+		n = n.children[i]
+	}
+
+	removed := n
+	t.size -= removed.count()
+
+	for d := len(path) - 1; d >= 0; d-- {
+		p := path[d]
+		i, _ := p.child(subs[d])
+		p.children = append(p.children[:i], p.children[i+1:]...)
+		if p.vb != nil || len(p.children) > 0 || d == 0 {
+			break
+		}
+	}
+
+	return removed
+}
+
+// attach grafts n at prefix, replacing anything below it
+func (t *oidTree) attach(prefix OID, n *oidNode) {
+	t.detach(prefix)
+	if n == nil || n.count() == 0 {
+		return
+	}
+
+	subs := prefix.Value
+	p := &t.root
+	for _, s := range subs[:len(subs)-1] {
+		i, ok := p.child(s)
+		if !ok {
+			c := &oidNode{sub: s}
+			p.children = append(p.children, nil)
+			copy(p.children[i+1:], p.children[i:])
+			p.children[i] = c
+		}
+		p = p.children[i]
+	}
+
+	last := subs[len(subs)-1]
+	n.sub = last
+	i, _ := p.child(last)
+	p.children = append(p.children, nil)
+	copy(p.children[i+1:], p.children[i:])
+	p.children[i] = n
+
+	t.size += n.count()
+}
+
+func (n *oidNode) first() *VarBind {
+	if n.vb != nil {
+		return n.vb
+	}
+	for _, c := range n.children {
+		if v := c.first(); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// after returns the first VarBind below n strictly after the relative path subs
+func (n *oidNode) after(subs []uint32) *VarBind {
+	var i int
+	if len(subs) > 0 {
+		var ok bool
+		i, ok = n.child(subs[0])
+		if ok {
+			if v := n.children[i].after(subs[1:]); v != nil {
+				return v
+			}
+			i++
+		}
+	}
+	for ; i < len(n.children); i++ {
+		if v := n.children[i].first(); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+func (t *oidTree) next(oid OID) *VarBind {
+	return t.root.after(oid.Value)
+}
+
+// walk calls fn for every VarBind at or below prefix in order until fn
+// returns false
+func (t *oidTree) walk(prefix OID, fn func(*VarBind) bool) {
+	n := t.find(prefix.Value)
+	if n != nil {
+		n.walk(fn)
+	}
+}
+
+func (n *oidNode) walk(fn func(*VarBind) bool) bool {
+	if n.vb != nil && !fn(n.vb) {
+		return false
+	}
+	for _, c := range n.children {
+		if !c.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *oidTree) list() []*VarBind {
+	out := make([]*VarBind, 0, t.size)
+	t.root.walk(func(vb *VarBind) bool {
+		out = append(out, vb)
+		return true
+	})
+	return out
+}
+
+// oidIter walks a tree in order from a position
+type oidIter struct {
+	stack []iterFrame
+	cur   *VarBind
+}
+
+type iterFrame struct {
+	node *oidNode
+	next int
+}
+
+// seek positions an iterator on the entry at oid, it returns nil if there
+// is no such entry
+func (t *oidTree) seek(oid OID) *oidIter {
+	it := &oidIter{stack: make([]iterFrame, 0, len(oid.Value)+1)}
+	n := &t.root
+	for _, s := range oid.Value {
+		i, ok := n.child(s)
+		if !ok {
+			return nil
+		}
+		it.stack = append(it.stack, iterFrame{node: n, next: i + 1})
+		n = n.children[i]
+	}
+	if n.vb == nil {
+		return nil
+	}
+	it.stack = append(it.stack, iterFrame{node: n})
+	it.cur = n.vb
+	return it
+}
+
+// advance moves to the following entry, nil at the end of the tree
+func (it *oidIter) advance() *VarBind {
+	for len(it.stack) > 0 {
+		top := &it.stack[len(it.stack)-1]
+		if top.next < len(top.node.children) {
+			c := top.node.children[top.next]
+			top.next++
+			it.stack = append(it.stack, iterFrame{node: c})
+			if c.vb != nil {
+				it.cur = c.vb
+				return it.cur
+			}
+			continue
+		}
+		it.stack = it.stack[:len(it.stack)-1]
+	}
+	it.cur = nil
+	return nil
+}
@@ -0,0 +1,93 @@
+package passpersist
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func randomOIDs(r *rand.Rand, n int) OIDs {
+	base := MustNewOID("1.3.6.1.4.1.30065.4.226")
+	seen := make(map[string]bool)
+	var out OIDs
+	for len(out) < n {
+		subs := make([]int, 1+r.Intn(4))
+		for i := range subs {
+			subs[i] = r.Intn(5)
+		}
+		o := base.MustAppend(subs)
+		if !seen[o.String()] {
+			seen[o.String()] = true
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+func TestOIDTreeOrder(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	oids := randomOIDs(r, 200)
+
+	tree := newOIDTree()
+	for i, o := range oids {
+		tree.set(NewVarBind(o, &IntVal{int32(i)}))
+	}
+	if tree.size != len(oids) {
+		t.Fatalf("expected size %d, got %d", len(oids), tree.size)
+	}
+
+	sorted := make(OIDs, len(oids))
+	copy(sorted, oids)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Compare(sorted[j]) < 0 })
+
+	list := tree.list()
+	for i, vb := range list {
+		if !vb.OID.Equal(sorted[i]) {
+			t.Fatalf("entry %d is %s, wanted %s", i, vb.OID, sorted[i])
+		}
+	}
+
+	// next agrees with the sorted list, the iterator agrees with next
+	it := tree.seek(sorted[0])
+	for i := 0; i < len(sorted)-1; i++ {
+		if v := tree.next(sorted[i]); v == nil || !v.OID.Equal(sorted[i+1]) {
+			t.Fatalf("next of %s is %v, wanted %s", sorted[i], v, sorted[i+1])
+		}
+		if v := it.advance(); v == nil || !v.OID.Equal(sorted[i+1]) {
+			t.Fatalf("iterator after %s is %v, wanted %s", sorted[i], v, sorted[i+1])
+		}
+	}
+	if tree.next(sorted[len(sorted)-1]) != nil || it.advance() != nil {
+		t.Error("expected the end of the tree")
+	}
+}
+
+func TestOIDTreeSubtree(t *testing.T) {
+	tree := newOIDTree()
+	base := MustNewOID("1.3.6.1.4.1.30065.4.226")
+	for _, subs := range [][]int{{1}, {10, 1}, {10, 2}, {10, 3, 1}, {11, 1}} {
+		tree.set(NewVarBind(base.MustAppend(subs), &IntVal{1}))
+	}
+
+	var under []string
+	tree.walk(base.MustAppend([]int{10}), func(vb *VarBind) bool {
+		under = append(under, vb.OID.String())
+		return true
+	})
+	if len(under) != 3 {
+		t.Errorf("expected 3 entries under .10, got %v", under)
+	}
+
+	n := tree.detach(base.MustAppend([]int{10}))
+	if n == nil || tree.size != 2 || tree.get(base.MustAppend([]int{10, 1})) != nil {
+		t.Fatalf("detach failed, size %d", tree.size)
+	}
+	if v := tree.next(base.MustAppend([]int{1})); v == nil || !v.OID.Equal(base.MustAppend([]int{11, 1})) {
+		t.Errorf("unexpected next after detach: %v", v)
+	}
+
+	tree.attach(base.MustAppend([]int{10}), n)
+	if tree.size != 5 || tree.get(base.MustAppend([]int{10, 3, 1})) == nil {
+		t.Errorf("attach failed, size %d", tree.size)
+	}
+}
@@ -4,10 +4,15 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/netip"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"os"
@@ -33,9 +38,18 @@ const (
 	NetPassExamples       = "1.3.6.1.4.1.8072.2.255"
 )
 
+// Exit statuses returned by Run
+const (
+	ExitOK    = 0
+	ExitError = 1
+)
+
 var (
 	DefaultBaseOID     OID           = MustNewOID(AristaExperimentalMib).MustAppend([]int{226})
 	DefaultRefreshRate time.Duration = time.Second * 60
+	// DefaultShutdownTimeout bounds how long Run waits for a refresh in
+	// progress when it stops
+	DefaultShutdownTimeout time.Duration = time.Second * 5
 )
 
 func init() {
@@ -78,10 +92,98 @@ func WithBaseOID(o OID) func(*PassPersist) {
 	}
 }
 
+// WithAgent serves the cache over UDP on addr instead of the
+// pass_persist protocol on stdin
+func WithAgent(addr string, opts ...AgentOption) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.agentAddr = addr
+		p.agentOpts = append(p.agentOpts, opts...)
+	}
+}
+
+// WithInput reads requests from r instead of stdin
+func WithInput(r io.Reader) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.in = r
+	}
+}
+
+// WithOutput writes responses to w instead of stdout
+func WithOutput(w io.Writer) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.out = w
+	}
+}
+
+// WithSignals sets the signals that stop Run, SIGINT and SIGTERM by
+// default. Passing none leaves signal handling to the caller.
+func WithSignals(sigs ...os.Signal) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.signals = sigs
+	}
+}
+
+func WithShutdownTimeout(d time.Duration) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.shutdownTimeout = d
+	}
+}
+
+// WithEnvOverride controls whether PASSPERSIST_* env vars override the
+// options, disable it when the env was already applied by the caller
+func WithEnvOverride(enabled bool) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.envOverride = enabled
+	}
+}
+
+// WithConfigDump adds v to the DUMPCONFIG output
+func WithConfigDump(v any) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.configDump = v
+	}
+}
+
 type PassPersist struct {
 	cache       *Cache
 	baseOID     OID
 	refreshRate time.Duration
+	agentAddr   string
+	agentOpts   []AgentOption
+	senders     []NotificationSender
+	start       time.Time
+	envOverride bool
+	configDump  any
+	subtrees    []*subtree
+	strictFatal bool
+	health      *health
+	lazy        *lazy
+	sched       schedule
+	snapshot    *snapshot
+	metricsAddr string
+	debugAddr   string
+	debug       bool
+	diagDir     string
+	limits      limits
+
+	// refreshes for the debug API
+	status  refreshStatus
+	trigger chan struct{}
+
+	// set by Fail during a refresh
+	failMu sync.Mutex
+	failed error
+
+	// named tables and the scalars added, by sub-ids, for DUMPMIB
+	tablesMu sync.Mutex
+	tables   map[string]*Table
+	scalars  map[string]mibScalar
+
+	in              io.Reader
+	out             io.Writer
+	signals         []os.Signal
+	shutdownTimeout time.Duration
+	ctx             context.Context
 }
 
 func NewPassPersist(opts ...Option) *PassPersist {
@@ -90,17 +192,50 @@ func NewPassPersist(opts ...Option) *PassPersist {
 		cache:       NewCache(),
 		baseOID:     DefaultBaseOID,
 		refreshRate: DefaultRefreshRate,
+		start:       time.Now(),
+		envOverride: true,
+
+		in:              os.Stdin,
+		out:             os.Stdout,
+		signals:         []os.Signal{os.Interrupt, unix.SIGTERM},
+		shutdownTimeout: DefaultShutdownTimeout,
 	}
 
 	for _, fn := range opts {
 		fn(p)
 	}
 
-	p.overrideFromEnv()
+	// limits and snapshots report through the health subtree
+	if l := p.limits; p.health == nil && (l.memory > 0 || l.maxEntries > 0 || p.snapshot != nil) {
+		p.health = &health{subs: DefaultHealthSubs}
+	}
+
+	if p.envOverride {
+		p.overrideFromEnv()
+	}
 
 	return p
 }
 
+func (p *PassPersist) BaseOID() OID {
+	return p.baseOID
+}
+
+// Context is cancelled when Run stops, collectors pass it to anything
+// long running such as EOS commands
+func (p *PassPersist) Context() context.Context {
+	if p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
+
+// OnChange calls fn with the added, removed and changed values after each
+// refresh that changed the cache
+func (p *PassPersist) OnChange(fn func(Diff)) {
+	p.cache.OnChange(fn)
+}
+
 func (p *PassPersist) AddEntry(subs []int, value typedValue) error {
 	oid, err := p.baseOID.Append(subs)
 	if err != nil {
@@ -116,6 +251,10 @@ func (p *PassPersist) AddEntry(subs []int, value typedValue) error {
 	})
 
 	if err != nil {
+		var ce *ConflictError
+		if p.strictFatal && errors.As(err, &ce) {
+			panic(err)
+		}
 		return err
 	}
 
@@ -162,85 +301,291 @@ func (p *PassPersist) AddTimeTicks(subIds []int, value time.Duration) error {
 	return p.AddEntry(subIds, typedValue{&TimeTicksVal{value}})
 }
 
-func (p *PassPersist) Run(ctx context.Context, f func(*PassPersist)) {
-	input := make(chan string)
-	done := make(chan bool)
+// Run refreshes the cache with f and answers pass_persist requests, or
+// SNMP requests in agent mode, until the input is closed, ctx is cancelled
+// or a shutdown signal arrives. It returns the process exit status.
+func (p *PassPersist) Run(ctx context.Context, f func(*PassPersist)) int {
+	ctx, cancel := context.WithCancel(ctx)
+	p.ctx = ctx
 
-	go p.update(ctx, f)
-	go watchStdin(ctx, input, done)
+	p.applyLimits()
 
-	for {
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		if !waitTimeout(&wg, p.shutdownTimeout) {
+			slog.Warn("refresh still running at shutdown", "timeout", p.shutdownTimeout)
+		}
+	}()
+
+	if err := p.initSubtrees(); err != nil {
+		slog.Error("invalid subtree", slog.Any("error", err))
+		return ExitError
+	}
+	p.warmStart()
+
+	if p.metricsAddr != "" {
+		if err := p.serveMetrics(ctx, &wg); err != nil {
+			slog.Error("failed to start metrics listener", slog.Any("error", err))
+			return ExitError
+		}
+	}
+
+	// f may be nil when everything is served from subtrees
+	if f != nil {
+		p.startRefresh(ctx, &wg, f)
+	}
+	p.startSubtrees(ctx, &wg)
+
+	if p.debugAddr != "" {
+		if err := p.serveDebug(ctx, &wg); err != nil {
+			slog.Error("failed to start debug listener", slog.Any("error", err))
+			return ExitError
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	if len(p.signals) > 0 {
+		signal.Notify(sig, p.signals...)
+		defer signal.Stop(sig)
+	}
+
+	if p.agentAddr != "" {
+		errc := make(chan error, 1)
+		go func() {
+			errc <- NewAgent(p, p.agentOpts...).ListenAndServe(ctx, p.agentAddr)
+		}()
 		select {
-		case line := <-input:
-			switch line {
-			case "PING":
-				fmt.Println("PONG")
-			case "getnext":
-				inp := <-input
-				slog.Debug("validating", "input", inp)
-				if oid, ok := p.convertAndValidateOID(inp); ok {
-					slog.Debug("getNext", "oid", oid.String())
-					v := p.getNext(oid)
-					if v != nil {
-						fmt.Println(v.Marshal())
-					} else {
-						fmt.Println("NONE")
-					}
-				} else {
-					slog.Warn("failed to validate input", "input", inp)
-					fmt.Println("NONE")
-				}
-
-			case "get":
-				inp := <-input
-				if oid, ok := p.convertAndValidateOID(inp); ok {
-					slog.Debug("get", "oid", oid.String())
-					v := p.get(oid)
-					if v != nil {
-						fmt.Println(v.Marshal())
-					} else {
-						fmt.Println("NONE")
-					}
-				} else {
-					fmt.Println("NONE")
-				}
-			case "set":
-				fmt.Println(NotWriteable.String())
-			case "DUMP", "C":
-				p.cache.Dump()
-			case "DUMPINDEX", "I":
-				p.cache.DumpIndex()
-			case "DUMPCONFIG", "O":
-				p.dumpConfig()
-			case "PANIC":
-				_ = make([]any, 0)[1]
-			default:
-				fmt.Println("NONE")
+		case err := <-errc:
+			if err != nil {
+				slog.Error("agent failed", slog.Any("error", err))
+				return ExitError
 			}
-		case <-done:
-			return
+			slog.Info("shutting down", "reason", "agent stopped")
+		case s := <-sig:
+			slog.Info("shutting down", "reason", "signal", "signal", s.String())
+			cancel()
+			<-errc
 		case <-ctx.Done():
-			return
+			slog.Info("shutting down", "reason", "context done")
+			<-errc
+		}
+		return ExitOK
+	}
+
+	return p.serve(ctx, sig)
+}
+
+// serve answers pass_persist requests read from the input
+func (p *PassPersist) serve(ctx context.Context, sig <-chan os.Signal) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	in := newLineReader(ctx, p.in)
+	defer func() {
+		// unblocks the reader when it can. A blocking read of stdin is not
+		// interrupted by Close, so the reader is not waited for and is left
+		// behind when stopping for a signal or the context, the process is
+		// going away anyway.
+		if c, ok := p.in.(io.Closer); ok {
+			c.Close()
+		}
+	}()
+
+	w := bufio.NewWriter(p.out)
+	defer w.Flush()
+
+	status := ExitOK
+	for {
+		line, ok, reason := p.nextLine(ctx, sig, in, &status)
+		if !ok {
+			slog.Info("shutting down", "reason", reason)
+			return status
+		}
+
+		switch line {
+		case "PING":
+			fmt.Fprintln(w, "PONG")
+		case "getnext", "get":
+			inp, ok, reason := p.nextLine(ctx, sig, in, &status)
+			if !ok {
+				slog.Info("shutting down", "reason", reason)
+				return status
+			}
+			oid, valid := p.convertAndValidateOID(inp)
+			if !valid {
+				slog.Warn("failed to validate input", "input", inp)
+				fmt.Fprintln(w, "NONE")
+				break
+			}
+			var v *VarBind
+			if line == "getnext" {
+				slog.Debug("getNext", "oid", oid)
+				v = p.getNext(oid)
+			} else {
+				slog.Debug("get", "oid", oid)
+				v = p.get(oid)
+			}
+			if v != nil {
+				fmt.Fprintln(w, v.Marshal())
+			} else {
+				fmt.Fprintln(w, "NONE")
+			}
+		case "set":
+			fmt.Fprintln(w, NotWriteable.String())
+		case "DUMP", "C":
+			p.dump(w)
+		case "DUMPINDEX", "I":
+			p.dumpIndex(w)
+		case "DUMPCONFIG", "O":
+			p.dumpConfig(w)
+		case "DUMPMIB", "M":
+			if err := p.WriteMIB(w, DefaultMIBModule); err != nil {
+				slog.Warn("failed to write MIB", slog.Any("error", err))
+			}
+		case "DIAGCPU", "P":
+			p.diag(w, "cpu")
+		case "DIAGHEAP", "H":
+			p.diag(w, "heap")
+		case "DIAGGOROUTINE", "G":
+			p.diag(w, "goroutine")
+		case "DIAGMEMSTATS", "S":
+			p.diag(w, "memstats")
+		case "PANIC":
+			if !p.debug {
+				fmt.Fprintln(w, "NONE")
+				break
+			}
+			_ = make([]any, 0)[1]
+		default:
+			fmt.Fprintln(w, "NONE")
+		}
+
+		if err := w.Flush(); err != nil {
+			slog.Error("failed to write response", slog.Any("error", err))
+			return ExitError
 		}
 	}
 }
 
-func (p *PassPersist) dumpConfig() {
-	b, err := json.MarshalIndent(map[string]any{
+// nextLine waits for a line of input, it returns false with the reason when
+// it is time to stop
+func (p *PassPersist) nextLine(ctx context.Context, sig <-chan os.Signal, in *lineReader, status *int) (string, bool, string) {
+	select {
+	case line := <-in.lines:
+		return line, true, ""
+	case <-in.done:
+		if in.err != nil {
+			slog.Error("failed to read input", slog.Any("error", in.err))
+			*status = ExitError
+			return "", false, "input error"
+		}
+		return "", false, "input closed"
+	case s := <-sig:
+		return "", false, "signal " + s.String()
+	case <-ctx.Done():
+		return "", false, "context done"
+	}
+}
+
+// lineReader feeds lines from r to a channel until r is exhausted or the
+// context is done, done is closed when it stops
+type lineReader struct {
+	lines chan string
+	done  chan struct{}
+	err   error
+}
+
+func newLineReader(ctx context.Context, r io.Reader) *lineReader {
+	l := &lineReader{
+		lines: make(chan string),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(l.done)
+
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := scanner.Text()
+			slog.Debug("got user input", "input", line)
+			select {
+			case l.lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			l.err = err
+		}
+	}()
+	return l
+}
+
+func (p *PassPersist) dumpConfig(w io.Writer) {
+	c := map[string]any{
 		"base-oid":     p.baseOID,
 		"refresh-rate": p.refreshRate,
-	}, "", "   ")
+	}
+	if p.agentAddr != "" {
+		c["agent-addr"] = p.agentAddr
+	}
+	if s := p.sched; s.jitter > 0 || s.align || s.backoffMax > 0 || s.sem != nil {
+		c["schedule"] = map[string]any{"jitter": s.jitter, "align": s.align, "backoff": s.backoffMax, "max-concurrent": cap(s.sem)}
+	}
+	if p.debugAddr != "" {
+		c["debug-addr"] = p.debugAddr
+	}
+	if p.debug {
+		c["debug"] = true
+	}
+	if l := p.limits; l.memory > 0 || l.maxProcs > 0 || l.maxEntries > 0 {
+		c["limits"] = map[string]any{"memory": l.memory, "max-procs": l.maxProcs, "max-entries": l.maxEntries, "policy": l.policy.String()}
+	}
+	if p.diagDir != "" {
+		c["diag-dir"] = p.diagDir
+	}
+	if p.metricsAddr != "" {
+		c["metrics-addr"] = p.metricsAddr
+	}
+	if p.snapshot != nil {
+		c["snapshot"] = map[string]any{"path": p.snapshot.path, "max-age": p.snapshot.maxAge}
+	}
+	if p.lazy != nil {
+		c["lazy"] = map[string]any{"min-interval": p.lazy.minInterval, "deadline": p.lazy.deadline}
+	}
+	if len(p.subtrees) > 0 {
+		var subtrees []map[string]any
+		for _, t := range p.subtrees {
+			if t.pp != nil {
+				subtrees = append(subtrees, map[string]any{"oid": t.pp.baseOID, "refresh-rate": t.pp.refreshRate})
+			}
+		}
+		c["subtrees"] = subtrees
+	}
+	if p.configDump != nil {
+		c["config"] = p.configDump
+	}
+	b, err := json.MarshalIndent(c, "", "   ")
 	if err != nil {
-		fmt.Println(err.Error())
+		fmt.Fprintln(w, err.Error())
 	}
-	fmt.Println(string(b))
+	fmt.Fprintln(w, string(b))
 }
 
 func (p *PassPersist) overrideFromEnv() {
+	// loaded first so the base OID below may be a name
+	if val, ok := os.LookupEnv("PASSPERSIST_MIB_DIRS"); ok {
+		for _, d := range filepath.SplitList(val) {
+			WithMIBDir(d)(p)
+		}
+	}
+
 	if val, ok := os.LookupEnv("PASSPERSIST_BASE_OID"); ok {
 		if o, err := NewOID(val); err == nil {
 			slog.Info("overriding base OID from env", "was", p.baseOID.String(), "now", o.String())
 			p.baseOID = o
+		} else {
+			slog.Warn("ignoring invalid PASSPERSIST_BASE_OID", slog.Any("error", err))
 		}
 	}
 
@@ -248,8 +593,40 @@ func (p *PassPersist) overrideFromEnv() {
 		if r, err := time.ParseDuration(val); err == nil {
 			slog.Info("overriding refresh rate from env", "was", p.refreshRate, "now", r)
 			p.refreshRate = r
+		} else {
+			slog.Warn("ignoring invalid PASSPERSIST_REFRESH_RATE", slog.Any("error", err))
 		}
 	}
+
+	if val, ok := os.LookupEnv("PASSPERSIST_AGENT_ADDR"); ok {
+		slog.Info("enabling agent from env", "addr", val)
+		p.agentAddr = val
+	}
+
+	if val, ok := os.LookupEnv("PASSPERSIST_AGENT_COMMUNITY"); ok {
+		p.agentOpts = append(p.agentOpts, WithCommunity(val))
+	}
+
+	// comma separated list of name:auth:authpass:priv:privpass
+	if val, ok := os.LookupEnv("PASSPERSIST_AGENT_USERS"); ok {
+		for _, s := range strings.Split(val, ",") {
+			u, err := ParseUSMUser(s)
+			if err != nil {
+				slog.Warn("ignoring invalid usm user from env", slog.Any("error", err))
+				continue
+			}
+			p.agentOpts = append(p.agentOpts, WithUSMUser(u))
+		}
+	}
+
+	if val, ok := os.LookupEnv("PASSPERSIST_TRAP_TARGET"); ok {
+		var opts []UDPSenderOption
+		if c, ok := os.LookupEnv("PASSPERSIST_TRAP_COMMUNITY"); ok {
+			opts = append(opts, WithTrapCommunity(c))
+		}
+		slog.Info("sending notifications from env", "target", val)
+		p.senders = append(p.senders, Dedup(RateLimit(NewUDPSender(val, opts...), time.Second, 10), time.Minute))
+	}
 }
 
 func setPrio(prio int) error {
@@ -274,56 +651,60 @@ func (p *PassPersist) update(ctx context.Context, callback func(*PassPersist)) {
 		slog.Warn("failed to set priority")
 	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			timer := time.NewTimer(p.refreshRate)
+	offset := p.sched.startDelay()
+	if !sleepCtx(ctx, offset) {
+		return
+	}
 
-			callback(p)
-			p.cache.Commit()
+	failures := 0
+	for {
+		start := time.Now()
+		if ok, _ := p.refreshOnce(ctx, callback); ok {
+			failures = 0
+		} else {
+			failures++
+		}
 
-			<-timer.C
+		if !p.waitNext(ctx, p.sched.nextDelay(time.Now(), start, p.refreshRate, failures, offset)) {
+			return
 		}
 	}
 }
 
-func (p *PassPersist) get(oid OID) *VarBind {
-	slog.Debug("getting oid", "oid", oid.String())
-	return p.cache.Get(oid)
-}
-
-func (p *PassPersist) getNext(oid OID) *VarBind {
-	return p.cache.GetNext(oid)
-}
-
-func watchStdin(ctx context.Context, input chan<- string, done chan<- bool) {
-
-	scanner := bufio.NewScanner(os.Stdin)
-
-	defer func() {
-		done <- true
+// waitTimeout waits for wg, it returns false if d elapsed first
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
 	}()
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-done:
+		return true
+	case <-t.C:
+		return false
+	}
+}
 
-	for scanner.Scan() {
-
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			line := scanner.Text()
-			slog.Debug("got user input", "input", line)
-			input <- line
+func (p *PassPersist) get(oid OID) *VarBind {
+	slog.Debug("getting oid", "oid", oid)
+	p.fresh(oid, false)
+	if t := p.route(oid); t != p {
+		if v := t.cache.Get(oid); v != nil {
+			return v
 		}
 	}
+	return p.cache.Get(oid)
+}
 
-	if err := scanner.Err(); err != nil {
-		if err != io.EOF {
-			slog.Error("scanner encountered an error", slog.Any("error", err.Error()))
-			os.Exit(1)
-		}
+func (p *PassPersist) getNext(oid OID) *VarBind {
+	p.fresh(oid, true)
+	if len(p.subtrees) == 0 {
+		return p.cache.GetNext(oid)
 	}
+	return p.nextAcross(oid)
 }
 
 func (p *PassPersist) convertAndValidateOID(oid string) (OID, bool) {
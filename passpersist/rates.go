@@ -0,0 +1,150 @@
+package passpersist
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+// Rates are derived from successive samples of a counter, in units per
+// RateTracker unit (per second by default)
+type Rates struct {
+	Rate   float64 `json:"rate"`
+	Avg1m  float64 `json:"avg-1m"`
+	Avg5m  float64 `json:"avg-5m"`
+	Avg15m float64 `json:"avg-15m"`
+	// Valid is false until two samples have been seen
+	Valid bool `json:"valid"`
+}
+
+type RateTrackerOption func(*RateTracker)
+
+// WithRateUnit sets the unit rates are expressed in, e.g. time.Minute
+// for per-minute rates of slow counters
+func WithRateUnit(d time.Duration) RateTrackerOption {
+	return func(t *RateTracker) {
+		t.unit = d
+	}
+}
+
+// RateTracker keeps the previous sample of each counter to compute rates
+// and exponentially weighted moving averages. The actual time between
+// samples is used, so refresh jitter does not skew the rates.
+type RateTracker struct {
+	mu      sync.Mutex
+	unit    time.Duration
+	samples map[string]*rateSample
+	now     func() time.Time
+}
+
+type rateSample struct {
+	value uint64
+	at    time.Time
+	rates Rates
+}
+
+func NewRateTracker(opts ...RateTrackerOption) *RateTracker {
+	t := &RateTracker{
+		unit:    time.Second,
+		samples: make(map[string]*rateSample),
+		now:     time.Now,
+	}
+
+	for _, fn := range opts {
+		fn(t)
+	}
+
+	return t
+}
+
+// Update records a counter sample for key and returns the updated rates.
+// A counter going backwards is treated as a reset, the previous rates are
+// kept and the sample becomes the new reference.
+func (t *RateTracker) Update(key string, value uint64) Rates {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+
+	s, ok := t.samples[key]
+	if !ok {
+		t.samples[key] = &rateSample{value: value, at: now}
+		return Rates{}
+	}
+
+	dt := now.Sub(s.at)
+	if dt <= 0 {
+		return s.rates
+	}
+
+	if value < s.value {
+		slog.Debug("counter reset, skipping rate sample", "key", key, "last", s.value, "value", value)
+		s.value = value
+		s.at = now
+		return s.rates
+	}
+
+	rate := float64(value-s.value) / (float64(dt) / float64(t.unit))
+
+	if !s.rates.Valid {
+		s.rates = Rates{Rate: rate, Avg1m: rate, Avg5m: rate, Avg15m: rate, Valid: true}
+	} else {
+		s.rates.Rate = rate
+		s.rates.Avg1m = ewma(s.rates.Avg1m, rate, dt, time.Minute)
+		s.rates.Avg5m = ewma(s.rates.Avg5m, rate, dt, time.Minute*5)
+		s.rates.Avg15m = ewma(s.rates.Avg15m, rate, dt, time.Minute*15)
+	}
+
+	s.value = value
+	s.at = now
+
+	return s.rates
+}
+
+// Forget drops the samples of a counter that no longer exists
+func (t *RateTracker) Forget(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.samples, key)
+}
+
+// ewma decays the average with the elapsed time, like the load average
+func ewma(avg float64, v float64, dt time.Duration, window time.Duration) float64 {
+	alpha := 1 - math.Exp(-float64(dt)/float64(window))
+	return avg + alpha*(v-avg)
+}
+
+func gauge(v float64) uint32 {
+	if v <= 0 || math.IsNaN(v) {
+		return 0
+	}
+	if v >= math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(math.Round(v))
+}
+
+// Gauges returns the rate and its 1, 5 and 15 minute averages rounded to
+// Gauge32 values
+func (r Rates) Gauges() [4]uint32 {
+	return [4]uint32{gauge(r.Rate), gauge(r.Avg1m), gauge(r.Avg5m), gauge(r.Avg15m)}
+}
+
+// AddRates adds the rate and its 1, 5 and 15 minute averages as Gauge32
+// values at subIds.1 to subIds.4, nothing is added until the rates are valid
+func (p *PassPersist) AddRates(subIds []int, r Rates) error {
+	if !r.Valid {
+		return nil
+	}
+
+	for i, v := range r.Gauges() {
+		s := append(append(make([]int, 0, len(subIds)+1), subIds...), i+1)
+		if err := p.AddGauge(s, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
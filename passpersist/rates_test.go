@@ -0,0 +1,61 @@
+package passpersist
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateTracker(t *testing.T) {
+	rt := NewRateTracker()
+	now := time.Unix(0, 0)
+	rt.now = func() time.Time { return now }
+
+	if r := rt.Update("requests", 1000); r.Valid {
+		t.Error("rates must not be valid after the first sample")
+	}
+
+	// a jittered refresh of 310s instead of 300s
+	now = now.Add(time.Second * 310)
+	r := rt.Update("requests", 1000+3100)
+	if !r.Valid || r.Rate != 10 || r.Avg15m != 10 {
+		t.Errorf("unexpected rates %+v", r)
+	}
+
+	now = now.Add(time.Second * 300)
+	r = rt.Update("requests", 4100+6000)
+	if r.Rate != 20 {
+		t.Errorf("got rate %f, wanted 20", r.Rate)
+	}
+	if !(r.Avg1m > r.Avg5m && r.Avg5m > r.Avg15m && r.Avg15m > 10) {
+		t.Errorf("averages are not decaying as expected: %+v", r)
+	}
+	if math.Abs(r.Avg15m-(10+10*(1-math.Exp(-300.0/900)))) > 1e-9 {
+		t.Errorf("unexpected 15m average %f", r.Avg15m)
+	}
+
+	// a reset keeps the previous rates instead of producing a spike
+	now = now.Add(time.Second * 300)
+	if got := rt.Update("requests", 50); got != r {
+		t.Errorf("rates changed on reset: %+v", got)
+	}
+
+	now = now.Add(time.Second * 100)
+	if got := rt.Update("requests", 150); got.Rate != 1 {
+		t.Errorf("got rate %f after reset, wanted 1", got.Rate)
+	}
+}
+
+func TestAddRates(t *testing.T) {
+	p := NewPassPersist(WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")))
+	p.AddRates([]int{1, 5}, Rates{Rate: 1.6, Avg1m: 1, Avg5m: 0.2, Avg15m: -1, Valid: true})
+	p.cache.Commit()
+
+	want := []uint32{2, 1, 0, 0}
+	for i, w := range want {
+		v := p.get(MustNewOID("1.3.6.1.4.1.8072.2.255.1.5").MustAppend([]int{i + 1}))
+		if v == nil || v.Value.GetGaugeVal() != w {
+			t.Errorf("gauge %d: got %v, wanted %d", i+1, v, w)
+		}
+	}
+}
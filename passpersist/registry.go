@@ -0,0 +1,89 @@
+package passpersist
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Extension is a collector registered by name so several of them can be
+// built into one binary
+type Extension struct {
+	Name    string
+	Collect func(*PassPersist)
+	// Refresh is the default refresh rate, zero keeps DefaultRefreshRate
+	Refresh time.Duration
+	// Flags registers extension specific flags before they are parsed
+	Flags func(*flag.FlagSet)
+	// Setup runs once the PassPersist is created, before the first refresh
+	Setup func(*PassPersist)
+}
+
+type ExtensionOption func(*Extension)
+
+func WithExtensionRefresh(d time.Duration) ExtensionOption {
+	return func(e *Extension) {
+		e.Refresh = d
+	}
+}
+
+func WithExtensionFlags(fn func(*flag.FlagSet)) ExtensionOption {
+	return func(e *Extension) {
+		e.Flags = fn
+	}
+}
+
+func WithExtensionSetup(fn func(*PassPersist)) ExtensionOption {
+	return func(e *Extension) {
+		e.Setup = fn
+	}
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]*Extension)
+)
+
+// Register makes an extension available by name, it panics if the name is
+// already taken. Call it from an init function.
+func Register(name string, collect func(*PassPersist), opts ...ExtensionOption) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if collect == nil {
+		panic("passpersist: Register collector is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("passpersist: Register called twice for extension %s", name))
+	}
+
+	e := &Extension{Name: name, Collect: collect}
+	for _, fn := range opts {
+		fn(e)
+	}
+	registry[name] = e
+}
+
+// LookupExtension returns the extension registered as name
+func LookupExtension(name string) (*Extension, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	e, ok := registry[name]
+	return e, ok
+}
+
+// Extensions returns the registered names in order
+func Extensions() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for n := range registry {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,27 @@
+package passpersist
+
+import "testing"
+
+func TestRegister(t *testing.T) {
+	Register("test-registry", func(*PassPersist) {}, WithExtensionRefresh(DefaultRefreshRate*2))
+
+	e, ok := LookupExtension("test-registry")
+	if !ok || e.Refresh != DefaultRefreshRate*2 {
+		t.Fatalf("unexpected extension %+v", e)
+	}
+
+	found := false
+	for _, n := range Extensions() {
+		found = found || n == "test-registry"
+	}
+	if !found {
+		t.Error("registered extension is not listed")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic registering a name twice")
+		}
+	}()
+	Register("test-registry", func(*PassPersist) {})
+}
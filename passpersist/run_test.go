@@ -0,0 +1,206 @@
+package passpersist
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// checkGoroutines fails if more goroutines than before are still running
+// once things had time to settle
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			n := runtime.Stack(buf, true)
+			t.Fatalf("leaked %d goroutines:\n%s", runtime.NumGoroutine()-before, buf[:n])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type runHarness struct {
+	in     *io.PipeWriter
+	out    *bufio.Reader
+	status chan int
+}
+
+func startRun(t *testing.T, ctx context.Context, f func(*PassPersist), opts ...Option) *runHarness {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	opts = append([]Option{
+		WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")),
+		WithInput(inR),
+		WithOutput(outW),
+		WithRefresh(time.Hour),
+		WithEnvOverride(false),
+	}, opts...)
+	p := NewPassPersist(opts...)
+
+	h := &runHarness{in: inW, out: bufio.NewReader(outR), status: make(chan int, 1)}
+	go func() {
+		h.status <- p.Run(ctx, f)
+		outW.Close()
+	}()
+	return h
+}
+
+func (h *runHarness) request(t *testing.T, lines ...string) string {
+	t.Helper()
+	if _, err := io.WriteString(h.in, strings.Join(lines, "\n")+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	l, err := h.out.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(l)
+}
+
+func (h *runHarness) wait(t *testing.T) int {
+	t.Helper()
+	select {
+	case s := <-h.status:
+		return s
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	return -1
+}
+
+func TestRunInputClosed(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	refreshed := make(chan struct{})
+	h := startRun(t, context.Background(), func(pp *PassPersist) {
+		pp.AddString([]int{1}, "up")
+		close(refreshed)
+	}, WithSignals())
+	<-refreshed
+
+	if got := h.request(t, "PING"); got != "PONG" {
+		t.Errorf("got %q, wanted PONG", got)
+	}
+	h.in.Close()
+
+	if s := h.wait(t); s != ExitOK {
+		t.Errorf("exit status %d", s)
+	}
+	checkGoroutines(t, before)
+}
+
+func TestRunCancelStopsRefresh(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	h := startRun(t, ctx, func(pp *PassPersist) {
+		close(started)
+		// a long running command that honours the context
+		<-pp.Context().Done()
+		close(stopped)
+	}, WithSignals())
+	<-started
+
+	cancel()
+	if s := h.wait(t); s != ExitOK {
+		t.Errorf("exit status %d", s)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("Run returned before the refresh stopped")
+	}
+	checkGoroutines(t, before)
+}
+
+func TestRunSignal(t *testing.T) {
+	// the first Notify starts a goroutine that lives as long as the process
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR2)
+	signal.Stop(c)
+	before := runtime.NumGoroutine()
+
+	h := startRun(t, context.Background(), nil, WithSignals(syscall.SIGUSR1))
+	if got := h.request(t, "PING"); got != "PONG" {
+		t.Fatalf("got %q, wanted PONG", got)
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	if s := h.wait(t); s != ExitOK {
+		t.Errorf("exit status %d", s)
+	}
+	checkGoroutines(t, before)
+}
+
+func TestRunShutdownTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	h := startRun(t, ctx, func(pp *PassPersist) {
+		close(started)
+		<-release // ignores the context
+	}, WithSignals(), WithShutdownTimeout(50*time.Millisecond))
+	<-started
+
+	cancel()
+	h.wait(t)
+}
+
+func TestRunBlockingStdin(t *testing.T) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR2)
+	signal.Stop(c)
+	before := runtime.NumGoroutine()
+
+	// like stdin from snmpd, a blocking pipe: reads block in the kernel
+	// and Close does not interrupt them
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		t.Fatal(err)
+	}
+	in := os.NewFile(uintptr(fds[0]), "stdin")
+	w := os.NewFile(uintptr(fds[1]), "snmpd")
+	outR, outW := io.Pipe()
+	defer outR.Close()
+
+	p := NewPassPersist(
+		WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")),
+		WithInput(in),
+		WithOutput(outW),
+		WithRefresh(time.Hour),
+		WithSignals(syscall.SIGUSR1),
+	)
+	status := make(chan int, 1)
+	go func() { status <- p.Run(context.Background(), nil) }()
+
+	io.WriteString(w, "PING\n")
+	if l, err := bufio.NewReader(outR).ReadString('\n'); err != nil || l != "PONG\n" {
+		t.Fatalf("got %q, %v", l, err)
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	select {
+	case <-status:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return with a blocked read of stdin")
+	}
+
+	// the reader returns once the writer goes away
+	w.Close()
+	checkGoroutines(t, before)
+}
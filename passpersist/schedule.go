@@ -0,0 +1,162 @@
+package passpersist
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// schedule spreads refreshes out so extensions started together do not
+// run their EOS commands at the same moment
+type schedule struct {
+	jitter     time.Duration
+	align      bool
+	backoffMax time.Duration
+	// sem bounds the refreshes running at once, shared with subtrees
+	sem chan struct{}
+}
+
+// WithJitter delays the first refresh by a random duration up to d. With
+// WithAlignedRefresh every refresh is offset from the wall clock multiple
+// by that duration, so extensions aligned on the same rate do not all run
+// at once.
+func WithJitter(d time.Duration) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.sched.jitter = d
+	}
+}
+
+// WithAlignedRefresh runs refreshes on wall clock multiples of the refresh
+// rate, e.g. on :00, :05, :10 with a 5 minute rate. The first refresh still
+// runs at startup.
+func WithAlignedRefresh() func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.sched.align = true
+	}
+}
+
+// WithBackoff doubles the time to the next refresh after each consecutive
+// failed refresh, up to max. See Fail.
+func WithBackoff(max time.Duration) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.sched.backoffMax = max
+	}
+}
+
+// WithMaxConcurrentRefresh lets at most n collectors of this PassPersist
+// and its subtrees refresh at once
+func WithMaxConcurrentRefresh(n int) func(*PassPersist) {
+	return func(p *PassPersist) {
+		if n > 0 {
+			p.sched.sem = make(chan struct{}, n)
+		}
+	}
+}
+
+// Fail marks the refresh in progress as failed, e.g. when an EOS command
+// errors. The values it added are dropped and the previous ones kept.
+func (p *PassPersist) Fail(err error) {
+	p.failMu.Lock()
+	defer p.failMu.Unlock()
+
+	if p.failed == nil {
+		p.failed = err
+	}
+}
+
+func (p *PassPersist) takeFailure() error {
+	p.failMu.Lock()
+	defer p.failMu.Unlock()
+
+	err := p.failed
+	p.failed = nil
+	return err
+}
+
+// refreshOnce runs f and commits its values. It returns false if the
+// refresh failed or was cut short by ctx, the error also holds the
+// conflicts of a committed refresh in strict mode.
+func (p *PassPersist) refreshOnce(ctx context.Context, f func(*PassPersist)) (ok bool, err error) {
+	if p.sched.sem != nil {
+		select {
+		case p.sched.sem <- struct{}{}:
+			defer func() { <-p.sched.sem }()
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	start := p.status.begin()
+	defer func() { p.status.end(start, ok, err) }()
+
+	if err := p.overMemoryLimit(); err != nil {
+		slog.Warn("skipping refresh, keeping previous values", "base-oid", p.baseOID, slog.Any("error", err))
+		p.publishFailure(err, 0)
+		return false, err
+	}
+
+	p.takeFailure()
+	f(p)
+	if err := ctx.Err(); err != nil {
+		// the refresh was cut short, keep the last complete one
+		p.cache.Rollback()
+		return false, err
+	}
+	err = p.takeFailure()
+	dropped := p.cache.StagedDropped()
+	if err == nil && dropped > 0 && p.limits.policy == FailRefresh {
+		err = fmt.Errorf("refresh added %d entries past the limit of %d", dropped, p.limits.maxEntries)
+	}
+	if err != nil {
+		slog.Warn("refresh failed, keeping previous values", "base-oid", p.baseOID, slog.Any("error", err))
+		p.cache.Rollback()
+		p.publishFailure(err, dropped)
+		return false, err
+	}
+	return true, p.commit()
+}
+
+// nextDelay returns the time from now to the next refresh of a refresh
+// that started at start after failures consecutive failures. Aligned
+// refreshes run offset after each wall clock multiple.
+func (s *schedule) nextDelay(now time.Time, start time.Time, rate time.Duration, failures int, offset time.Duration) time.Duration {
+	d := rate
+	if failures > 0 && s.backoffMax > rate {
+		for i := 0; i < failures && d < s.backoffMax; i++ {
+			d *= 2
+		}
+		if d > s.backoffMax {
+			d = s.backoffMax
+		}
+	}
+	if s.align {
+		return now.Add(-offset).Truncate(d).Add(d + offset).Sub(now)
+	}
+	if next := start.Add(d).Sub(now); next > 0 {
+		return next
+	}
+	return 0
+}
+
+func (s *schedule) startDelay() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.jitter)))
+}
+
+// sleepCtx waits for d, it returns false if ctx is done first
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
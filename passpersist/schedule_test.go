@@ -0,0 +1,136 @@
+package passpersist
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNextDelay(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 3, 10, 0, time.UTC)
+
+	tests := []struct {
+		s        schedule
+		start    time.Time
+		rate     time.Duration
+		failures int
+		want     time.Duration
+	}{
+		// start to start
+		{schedule{}, now.Add(-10 * time.Second), time.Minute, 0, 50 * time.Second},
+		{schedule{}, now.Add(-2 * time.Minute), time.Minute, 0, 0},
+		// next :05
+		{schedule{align: true}, now, 5 * time.Minute, 0, time.Minute + 50*time.Second},
+		// doubled per failure, capped
+		{schedule{backoffMax: time.Hour}, now, time.Minute, 2, 4 * time.Minute},
+		{schedule{backoffMax: 5 * time.Minute}, now, time.Minute, 10, 5 * time.Minute},
+		{schedule{backoffMax: 5 * time.Minute}, now, time.Minute, 0, time.Minute},
+		// no backoff configured
+		{schedule{}, now, time.Minute, 3, time.Minute},
+		// aligned on the backed off interval
+		{schedule{align: true, backoffMax: time.Hour}, now, 5 * time.Minute, 1, 6*time.Minute + 50*time.Second},
+	}
+	for i, tt := range tests {
+		if got := tt.s.nextDelay(now, tt.start, tt.rate, tt.failures, 0); got != tt.want {
+			t.Errorf("%d: got %s, wanted %s", i, got, tt.want)
+		}
+	}
+
+	// the jitter offsets every aligned refresh, next :05:20
+	s := schedule{align: true, jitter: time.Minute}
+	if got := s.nextDelay(now, now, 5*time.Minute, 0, 20*time.Second); got != 2*time.Minute+10*time.Second {
+		t.Errorf("got %s with an offset", got)
+	}
+	// before the offset of the previous multiple, next :04:00
+	if got := s.nextDelay(now, now, 5*time.Minute, 0, 4*time.Minute); got != 50*time.Second {
+		t.Errorf("got %s with an offset", got)
+	}
+}
+
+func TestStartDelay(t *testing.T) {
+	s := schedule{jitter: 50 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		if d := s.startDelay(); d < 0 || d >= s.jitter {
+			t.Fatalf("delay %s out of range", d)
+		}
+	}
+	if d := (&schedule{}).startDelay(); d != 0 {
+		t.Errorf("delay %s without jitter", d)
+	}
+}
+
+func TestFailKeepsPreviousValues(t *testing.T) {
+	p := NewPassPersist(
+		WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")),
+		WithHealth([]int{99}),
+		WithEnvOverride(false),
+	)
+	oid := p.baseOID.MustAppend([]int{1})
+
+	if err := p.Refresh(func(pp *PassPersist) { pp.AddString([]int{1}, "up") }); err != nil {
+		t.Fatal(err)
+	}
+	err := p.Refresh(func(pp *PassPersist) {
+		pp.AddString([]int{2}, "partial")
+		pp.Fail(errors.New("Cli timed out"))
+	})
+	if err == nil || err.Error() != "Cli timed out" {
+		t.Errorf("got %v", err)
+	}
+	if v := p.get(oid); v == nil || v.Value.String() != "up" {
+		t.Errorf("previous value lost, got %v", v)
+	}
+	if v := p.get(p.baseOID.MustAppend([]int{2})); v != nil {
+		t.Errorf("value of the failed refresh served: %v", v)
+	}
+
+	// the failure shows in the health subtree after the next refresh
+	p.Refresh(func(pp *PassPersist) { pp.AddString([]int{1}, "up") })
+	if v := p.get(p.baseOID.MustAppend([]int{99, 4, 0})); v == nil || v.Value.String() != "Cli timed out" {
+		t.Errorf("last error: got %v", v)
+	}
+}
+
+func TestMaxConcurrentRefresh(t *testing.T) {
+	p := NewPassPersist(
+		WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")),
+		WithMaxConcurrentRefresh(1),
+		WithRefresh(time.Hour),
+		WithEnvOverride(false),
+	)
+
+	var running, peak atomic.Int32
+	var done sync.WaitGroup
+	collect := func(pp *PassPersist) {
+		defer done.Done()
+		n := running.Add(1)
+		for {
+			m := peak.Load()
+			if n <= m || peak.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		running.Add(-1)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := p.AddSubtree([]int{i}, 0, collect); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done.Add(3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	p.startSubtrees(ctx, &wg)
+	done.Wait()
+	cancel()
+	wg.Wait()
+
+	if n := peak.Load(); n != 1 {
+		t.Errorf("%d refreshes ran at once, wanted 1", n)
+	}
+}
@@ -0,0 +1,225 @@
+package passpersist
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// snapshotVersion is bumped when the file format changes, older files are
+// ignored
+const snapshotVersion = 1
+
+type snapshot struct {
+	path   string
+	maxAge time.Duration
+}
+
+type snapshotFile struct {
+	Version int             `json:"version"`
+	BaseOID string          `json:"base-oid"`
+	SavedAt time.Time       `json:"saved-at"`
+	Entries []snapshotEntry `json:"entries"`
+}
+
+// snapshotEntry keeps the Go type of the value, TypeString is ambiguous
+// for IPv6 addresses and lossy for binary octet strings
+type snapshotEntry struct {
+	OID   string `json:"oid"`
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// WithSnapshot saves the committed values to path after each refresh and
+// loads them at startup, so requests are answered before the first refresh
+// completes. Values loaded are marked stale in the health subtree until
+// then, it is served at DefaultHealthSubs without WithHealth. Snapshots older than maxAge are ignored, zero accepts any age.
+// Subtrees save next to it, in path plus their sub-ids.
+func WithSnapshot(path string, maxAge time.Duration) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.snapshot = &snapshot{path: path, maxAge: maxAge}
+	}
+}
+
+func encodeValue(v typedValue) (string, string, error) {
+	switch x := v.GetValue().(type) {
+	case *StringVal:
+		return "string", x.Value, nil
+	case *IntVal:
+		return "int", strconv.FormatInt(int64(x.Value), 10), nil
+	case *Counter32Val:
+		return "counter32", strconv.FormatUint(uint64(x.Value), 10), nil
+	case *Counter64Val:
+		return "counter64", strconv.FormatUint(x.Value, 10), nil
+	case *GaugeVal:
+		return "gauge", strconv.FormatUint(uint64(x.Value), 10), nil
+	case *OctetStringVal:
+		return "octets", base64.StdEncoding.EncodeToString(x.Value), nil
+	case *IPAddrVal:
+		return "ip", x.Value.String(), nil
+	case *IPV6AddrVal:
+		return "ipv6", x.Value.String(), nil
+	case *OIDVal:
+		return "oid", x.Value.String(), nil
+	case *TimeTicksVal:
+		return "timeticks", strconv.FormatInt(int64(x.Value), 10), nil
+	}
+	return "", "", fmt.Errorf("unsupported value type %T", v.GetValue())
+}
+
+func decodeValue(kind string, s string) (isTypedValue, error) {
+	switch kind {
+	case "string":
+		return &StringVal{s}, nil
+	case "int":
+		n, err := strconv.ParseInt(s, 10, 32)
+		return &IntVal{int32(n)}, err
+	case "counter32":
+		n, err := strconv.ParseUint(s, 10, 32)
+		return &Counter32Val{uint32(n)}, err
+	case "counter64":
+		n, err := strconv.ParseUint(s, 10, 64)
+		return &Counter64Val{n}, err
+	case "gauge":
+		n, err := strconv.ParseUint(s, 10, 32)
+		return &GaugeVal{uint32(n)}, err
+	case "octets":
+		b, err := base64.StdEncoding.DecodeString(s)
+		return &OctetStringVal{b}, err
+	case "ip":
+		a, err := netip.ParseAddr(s)
+		return &IPAddrVal{a}, err
+	case "ipv6":
+		a, err := netip.ParseAddr(s)
+		return &IPV6AddrVal{a}, err
+	case "oid":
+		o, err := ParseOID(s)
+		return &OIDVal{o}, err
+	case "timeticks":
+		n, err := strconv.ParseInt(s, 10, 64)
+		return &TimeTicksVal{time.Duration(n)}, err
+	}
+	return nil, fmt.Errorf("unknown value kind %q", kind)
+}
+
+// saveSnapshot writes the committed values, replacing the file atomically
+func (p *PassPersist) saveSnapshot() error {
+	vbs := p.cache.Walk(OID{}, p.cache.Len())
+	f := snapshotFile{
+		Version: snapshotVersion,
+		BaseOID: p.baseOID.String(),
+		SavedAt: time.Now(),
+		Entries: make([]snapshotEntry, 0, len(vbs)),
+	}
+	for _, vb := range vbs {
+		kind, value, err := encodeValue(vb.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", vb.OID, err)
+		}
+		f.Entries = append(f.Entries, snapshotEntry{OID: vb.OID.String(), Kind: kind, Value: value})
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	path := p.snapshot.path
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadSnapshot commits the values of a usable snapshot, it returns false if
+// there is none
+func (p *PassPersist) loadSnapshot() (bool, error) {
+	b, err := os.ReadFile(p.snapshot.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var f snapshotFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return false, err
+	}
+	if f.Version != snapshotVersion {
+		return false, fmt.Errorf("unsupported snapshot version %d", f.Version)
+	}
+	if f.BaseOID != p.baseOID.String() {
+		return false, fmt.Errorf("snapshot is for base OID %s", f.BaseOID)
+	}
+	if age := time.Since(f.SavedAt); p.snapshot.maxAge > 0 && age > p.snapshot.maxAge {
+		return false, fmt.Errorf("snapshot is %s old", age.Truncate(time.Second))
+	}
+
+	// the saved health values are skipped, the refresh and error counters
+	// restart at zero and would go backwards
+	var healthOID OID
+	if p.health != nil {
+		healthOID = p.baseOID.MustAppend(p.health.subs)
+	}
+	// decoded first so a bad entry leaves nothing staged
+	vbs := make([]*VarBind, 0, len(f.Entries))
+	for _, e := range f.Entries {
+		o, err := ParseOID(e.OID)
+		if err != nil {
+			return false, err
+		}
+		if !o.StartsWith(p.baseOID) {
+			return false, fmt.Errorf("%s is outside the base OID", o)
+		}
+		if p.health != nil && o.StartsWith(healthOID) {
+			continue
+		}
+		v, err := decodeValue(e.Kind, e.Value)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", e.OID, err)
+		}
+		vbs = append(vbs, NewVarBind(o, v))
+	}
+
+	for _, vb := range vbs {
+		p.cache.Set(vb)
+	}
+	if p.health != nil {
+		p.cache.setUncapped(NewVarBind(p.baseOID.MustAppend(p.health.staleSubs()), &IntVal{TruthValue.Value("true")}))
+	}
+	p.cache.Commit()
+
+	slog.Info("loaded snapshot", "path", p.snapshot.path, "saved-at", f.SavedAt, "entries", len(f.Entries))
+	return true, nil
+}
+
+// warmStart loads the snapshots of p and its subtrees
+func (p *PassPersist) warmStart() {
+	for _, pp := range append([]*PassPersist{p}, p.subtreePPs()...) {
+		if pp.snapshot == nil {
+			continue
+		}
+		if _, err := pp.loadSnapshot(); err != nil {
+			slog.Warn("ignoring snapshot", "path", pp.snapshot.path, slog.Any("error", err))
+		}
+	}
+}
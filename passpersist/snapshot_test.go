@@ -0,0 +1,157 @@
+package passpersist
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSnapshotPP(path string, maxAge time.Duration) *PassPersist {
+	return NewPassPersist(
+		WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")),
+		WithSnapshot(path, maxAge),
+		WithHealth([]int{99}),
+		WithEnvOverride(false),
+	)
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vrf.snapshot")
+
+	p := newSnapshotPP(path, time.Hour)
+	err := p.Refresh(func(pp *PassPersist) {
+		pp.AddString([]int{1}, "up")
+		pp.AddInt([]int{2}, -3)
+		pp.AddCounter32([]int{3}, 4)
+		pp.AddCounter64([]int{4}, 1<<40)
+		pp.AddGauge([]int{5}, 6)
+		pp.AddOctetString([]int{6}, []byte{0, 0xff, 'a'})
+		pp.AddIP([]int{7}, netip.MustParseAddr("10.0.0.1"))
+		pp.AddIPV6([]int{8}, netip.MustParseAddr("2001:db8::1"))
+		pp.AddOID([]int{9}, MustNewOID("1.3.6.1"))
+		pp.AddTimeTicks([]int{10}, 1234*time.Millisecond)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := newSnapshotPP(path, time.Hour)
+	if ok, err := q.loadSnapshot(); !ok || err != nil {
+		t.Fatalf("snapshot not loaded: %v", err)
+	}
+
+	// the health values are not restored, only the stale marker is set
+	health := q.baseOID.MustAppend([]int{99})
+	var want []*VarBind
+	for _, vb := range p.cache.Walk(OID{}, p.cache.Len()) {
+		if !vb.OID.StartsWith(health) {
+			want = append(want, vb)
+		}
+	}
+	got := q.cache.Walk(OID{}, q.cache.Len())
+	if len(got) != len(want)+1 {
+		t.Fatalf("loaded %d entries, wanted %d", len(got), len(want)+1)
+	}
+	for i := range want {
+		if got[i].Marshal() != want[i].Marshal() {
+			t.Errorf("got %q, wanted %q", got[i].Marshal(), want[i].Marshal())
+		}
+	}
+	stale := q.baseOID.MustAppend([]int{99, 5, 0})
+	if v := q.get(stale); v == nil || v.Value.String() != "1" {
+		t.Errorf("loaded values not marked stale: %v", v)
+	}
+	if v := q.get(q.baseOID.MustAppend([]int{99, 1, 0})); v != nil {
+		t.Errorf("refresh counter restored from the snapshot: %v", v)
+	}
+	if v := q.get(q.baseOID.MustAppend([]int{8})); v == nil || v.Value.GetIPV6AddrVal() != netip.MustParseAddr("2001:db8::1") {
+		t.Errorf("IPv6 value lost its type: %v", v)
+	}
+
+	// the first refresh clears the stale marker
+	q.Refresh(func(pp *PassPersist) { pp.AddString([]int{1}, "up") })
+	if v := q.get(stale); v == nil || v.Value.String() != "2" {
+		t.Errorf("stale marker after refresh: %v", v)
+	}
+}
+
+func TestSnapshotEnablesHealth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vrf.snapshot")
+	p := newSnapshotPP(path, 0)
+	p.Refresh(func(pp *PassPersist) { pp.AddString([]int{1}, "up") })
+
+	// the stale marker is served at DefaultHealthSubs without WithHealth
+	q := NewPassPersist(
+		WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")),
+		WithSnapshot(path, 0),
+		WithEnvOverride(false),
+	)
+	if ok, err := q.loadSnapshot(); !ok || err != nil {
+		t.Fatalf("snapshot not loaded: %v", err)
+	}
+	if v := q.get(q.baseOID.MustAppend([]int{99, 5, 0})); v == nil || v.Value.String() != "1" {
+		t.Errorf("loaded values not marked stale: %v", v)
+	}
+}
+
+func TestSnapshotRejected(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name string, f snapshotFile) string {
+		b, _ := json.Marshal(f)
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, b, 0o644)
+		return path
+	}
+	base := "1.3.6.1.4.1.8072.2.255"
+	entry := []snapshotEntry{{OID: base + ".1", Kind: "string", Value: "up"}}
+
+	for _, tt := range []struct {
+		name string
+		f    snapshotFile
+		want string
+	}{
+		{"version", snapshotFile{Version: 99, BaseOID: base, SavedAt: time.Now(), Entries: entry}, "version"},
+		{"base", snapshotFile{Version: snapshotVersion, BaseOID: "1.3.6.1.3.53", SavedAt: time.Now(), Entries: entry}, "base OID"},
+		{"age", snapshotFile{Version: snapshotVersion, BaseOID: base, SavedAt: time.Now().Add(-2 * time.Hour), Entries: entry}, "old"},
+		{"kind", snapshotFile{Version: snapshotVersion, BaseOID: base, SavedAt: time.Now(), Entries: append(entry,
+			snapshotEntry{OID: base + ".2", Kind: "float", Value: "1.5"})}, "unknown value kind"},
+	} {
+		p := newSnapshotPP(write(tt.name, tt.f), time.Hour)
+		ok, err := p.loadSnapshot()
+		if ok || err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, %v", tt.name, ok, err)
+		}
+		if p.cache.Len() != 0 || len(p.cache.StagedErrors()) != 0 {
+			t.Errorf("%s: rejected snapshot left values behind", tt.name)
+		}
+	}
+
+	p := newSnapshotPP(filepath.Join(dir, "missing"), 0)
+	if ok, err := p.loadSnapshot(); ok || err != nil {
+		t.Errorf("missing snapshot: got %v, %v", ok, err)
+	}
+}
+
+func TestSnapshotServedBeforeFirstRefresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vrf.snapshot")
+	p := newSnapshotPP(path, 0)
+	p.Refresh(func(pp *PassPersist) { pp.AddString([]int{1}, "up") })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := startRun(t, ctx, func(pp *PassPersist) {
+		// the first refresh takes a while
+		<-pp.Context().Done()
+	}, WithSignals(), WithSnapshot(path, 0))
+
+	if got := h.request(t, "get", "1.3.6.1.4.1.8072.2.255.1"); got != "1.3.6.1.4.1.8072.2.255.1" {
+		t.Errorf("got %q, wanted the snapshot value", got)
+	}
+	cancel()
+	h.wait(t)
+}
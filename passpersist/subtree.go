@@ -0,0 +1,173 @@
+package passpersist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// subtree is an independently refreshed part of the served tree, it has
+// its own PassPersist so collectors add entries relative to its OID
+type subtree struct {
+	subs    []int
+	refresh time.Duration
+	collect func(*PassPersist)
+	pp      *PassPersist
+}
+
+// WithSubtree serves the values from collect under the base OID plus subs,
+// refreshed every refresh independently of the main collector and of other
+// subtrees
+func WithSubtree(subs []int, refresh time.Duration, collect func(*PassPersist)) func(*PassPersist) {
+	return func(p *PassPersist) {
+		p.subtrees = append(p.subtrees, &subtree{subs: subs, refresh: refresh, collect: collect})
+	}
+}
+
+// AddSubtree is like WithSubtree for an existing PassPersist, call it
+// before Run. It returns the PassPersist the collector is called with.
+func (p *PassPersist) AddSubtree(subs []int, refresh time.Duration, collect func(*PassPersist)) (*PassPersist, error) {
+	t := &subtree{subs: subs, refresh: refresh, collect: collect}
+	if err := p.initSubtree(t); err != nil {
+		return nil, err
+	}
+	p.subtrees = append(p.subtrees, t)
+	return t.pp, nil
+}
+
+// initSubtree creates the subtree's PassPersist once the base OID is final
+func (p *PassPersist) initSubtree(t *subtree) error {
+	if t.pp != nil {
+		return nil
+	}
+	if len(t.subs) == 0 {
+		return fmt.Errorf("subtree must be below the base OID %s", p.baseOID)
+	}
+	oid, err := p.baseOID.Append(t.subs)
+	if err != nil {
+		return err
+	}
+	for _, o := range p.subtrees {
+		if o.pp != nil && (o.pp.baseOID.Contains(oid) || oid.Contains(o.pp.baseOID)) {
+			return fmt.Errorf("subtree %s overlaps %s", oid, o.pp.baseOID)
+		}
+	}
+	refresh := t.refresh
+	if refresh <= 0 {
+		refresh = p.refreshRate
+	}
+	t.pp = &PassPersist{
+		cache:       NewCache(),
+		baseOID:     oid,
+		refreshRate: refresh,
+		senders:     p.senders,
+		start:       p.start,
+		strictFatal: p.strictFatal,
+		sched:       p.sched,
+		limits:      p.limits,
+	}
+	t.pp.cache.SetStrict(p.cache.strict)
+	t.pp.cache.SetMaxEntries(p.limits.maxEntries)
+	if p.snapshot != nil {
+		t.pp.snapshot = &snapshot{
+			path:   p.snapshot.path + "." + OID{oid.Value[len(p.baseOID.Value):]}.String(),
+			maxAge: p.snapshot.maxAge,
+		}
+	}
+	if p.health != nil {
+		// served below the subtree, the main collector may be nil
+		t.pp.health = &health{subs: p.health.subs}
+	}
+	if p.lazy != nil {
+		t.pp.lazy = &lazy{minInterval: p.lazy.minInterval, deadline: p.lazy.deadline}
+	}
+	return nil
+}
+
+func (p *PassPersist) initSubtrees() error {
+	for _, t := range p.subtrees {
+		if err := p.initSubtree(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subtrees returns the base OIDs of the subtrees served next to the main
+// collector
+func (p *PassPersist) Subtrees() OIDs {
+	var out OIDs
+	for _, t := range p.subtrees {
+		if t.pp != nil {
+			out = append(out, t.pp.baseOID)
+		}
+	}
+	return out
+}
+
+func (p *PassPersist) startSubtrees(ctx context.Context, wg *sync.WaitGroup) {
+	for _, t := range p.subtrees {
+		slog.Debug("starting subtree", "oid", t.pp.baseOID, "refresh", t.pp.refreshRate)
+		t.pp.ctx = ctx
+		t.pp.startRefresh(ctx, wg, t.collect)
+	}
+}
+
+// route returns the subtree holding oid, or p itself
+func (p *PassPersist) route(oid OID) *PassPersist {
+	for _, t := range p.subtrees {
+		if t.pp != nil && oid.Contains(t.pp.baseOID) {
+			return t.pp
+		}
+	}
+	return p
+}
+
+// nextAcross returns the smallest entry after oid in any cache. Subtrees
+// that end before oid are skipped.
+func (p *PassPersist) nextAcross(oid OID) *VarBind {
+	next := p.cache.GetNext(oid)
+	for _, t := range p.subtrees {
+		if t.pp == nil {
+			continue
+		}
+		if b := t.pp.baseOID; oid.Compare(b) > 0 && !oid.Contains(b) {
+			continue
+		}
+		v := t.pp.cache.GetNext(oid)
+		if v != nil && (next == nil || v.OID.Compare(next.OID) < 0) {
+			next = v
+		}
+	}
+	return next
+}
+
+func (p *PassPersist) dump(w io.Writer) {
+	var v any = p.cache.dumpEntries()
+	if len(p.subtrees) > 0 {
+		m := map[string]any{p.baseOID.String(): v}
+		for _, t := range p.subtrees {
+			m[t.pp.baseOID.String()] = t.pp.cache.dumpEntries()
+		}
+		v = m
+	}
+	o, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Fprintln(w, string(o))
+}
+
+func (p *PassPersist) dumpIndex(w io.Writer) {
+	var v any = p.cache.index()
+	if len(p.subtrees) > 0 {
+		m := map[string]OIDs{p.baseOID.String(): p.cache.index()}
+		for _, t := range p.subtrees {
+			m[t.pp.baseOID.String()] = t.pp.cache.index()
+		}
+		v = m
+	}
+	o, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Fprintln(w, string(o))
+}
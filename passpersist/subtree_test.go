@@ -0,0 +1,67 @@
+package passpersist
+
+import "testing"
+
+func TestSubtreeRouting(t *testing.T) {
+	p := NewPassPersist(
+		WithBaseOID(MustNewOID("1.3.6.1.3")),
+		WithSubtree([]int{54}, 0, func(pp *PassPersist) {
+			pp.AddString([]int{1}, "dhcp")
+			pp.AddString([]int{2, 1}, "relay")
+		}),
+		WithSubtree([]int{53}, 0, func(pp *PassPersist) {
+			pp.AddString([]int{1}, "vrf")
+		}),
+	)
+	if err := p.initSubtrees(); err != nil {
+		t.Fatal(err)
+	}
+
+	p.AddString([]int{1}, "main")
+	p.AddString([]int{60}, "after")
+	p.cache.Commit()
+	for _, st := range p.subtrees {
+		st.collect(st.pp)
+		st.pp.cache.Commit()
+	}
+
+	want := []string{"1.3.6.1.3.1", "1.3.6.1.3.53.1", "1.3.6.1.3.54.1", "1.3.6.1.3.54.2.1", "1.3.6.1.3.60"}
+	o := p.BaseOID()
+	for _, w := range want {
+		v := p.getNext(o)
+		if v == nil || v.OID.String() != w {
+			t.Fatalf("next after %s is %v, wanted %s", o, v, w)
+		}
+		o = v.OID
+	}
+	if v := p.getNext(o); v != nil {
+		t.Errorf("expected the end of the tree, got %s", v.OID)
+	}
+
+	if v := p.get(MustNewOID("1.3.6.1.3.54.2.1")); v == nil || v.Value.String() != "relay" {
+		t.Errorf("get was not routed to the subtree: %v", v)
+	}
+
+	if _, err := p.AddSubtree([]int{53, 1}, 0, func(*PassPersist) {}); err == nil {
+		t.Error("expected an error for an overlapping subtree")
+	}
+}
+
+func TestSubtreeHealth(t *testing.T) {
+	p := NewPassPersist(
+		WithBaseOID(MustNewOID("1.3.6.1.3")),
+		WithHealth([]int{99}),
+	)
+	pp, err := p.AddSubtree([]int{54}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pp.Refresh(func(pp *PassPersist) { pp.AddString([]int{1}, "dhcp") }); err != nil {
+		t.Fatal(err)
+	}
+
+	// published without a main collector
+	if v := p.get(MustNewOID("1.3.6.1.3.54.99.1.0")); v == nil || v.Value.String() != "1" {
+		t.Errorf("got %v, wanted 1 refresh", v)
+	}
+}
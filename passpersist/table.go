@@ -0,0 +1,208 @@
+package passpersist
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// Column describes a column of a conceptual table
+type Column struct {
+	// ID is the column sub-id below the entry, starting at 1
+	ID int
+	// Type is the value type, as returned by VarBind.ValueType, e.g.
+	// STRING or Counter64
+	Type string
+	Name string
+	// Enum maps state strings to the column's INTEGER values, see
+	// Row.SetEnum. Type defaults to INTEGER.
+	Enum *Enum
+}
+
+// Table builds a conceptual table below the base OID, values are served
+// as table.1.column.index so a walk returns each column in turn
+type Table struct {
+	pp      *PassPersist
+	name    string
+	subs    []int
+	columns map[int]Column
+	order   []int
+
+	mu    sync.Mutex
+	index []string
+}
+
+// NewTable returns a table at subs below the base OID. Table and column
+// names are registered with DefaultMIBResolver and show up in DUMP, the
+// entry is named after the table with Table replaced by Entry.
+func (p *PassPersist) NewTable(name string, subs []int, columns ...Column) (*Table, error) {
+	t := &Table{pp: p, name: name, subs: subs, columns: make(map[int]Column, len(columns))}
+
+	for _, c := range columns {
+		if c.Enum != nil && c.Type == "" {
+			c.Type = "INTEGER"
+		}
+		if c.ID < 1 {
+			return nil, fmt.Errorf("table %s: invalid column id %d", name, c.ID)
+		}
+		if _, dup := t.columns[c.ID]; dup {
+			return nil, fmt.Errorf("table %s: duplicate column id %d", name, c.ID)
+		}
+		t.columns[c.ID] = c
+		t.order = append(t.order, c.ID)
+	}
+
+	oid, err := p.baseOID.Append(subs)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		DefaultMIBResolver.Add("", name, oid)
+		DefaultMIBResolver.Add("", t.entryName(), oid.MustAppend([]int{1}))
+
+		p.tablesMu.Lock()
+		if p.tables == nil {
+			p.tables = make(map[string]*Table)
+		}
+		p.tables[name] = t
+		p.tablesMu.Unlock()
+	}
+	for _, c := range columns {
+		if c.Name != "" {
+			DefaultMIBResolver.Add("", c.Name, oid.MustAppend([]int{1, c.ID}))
+		}
+	}
+
+	return t, nil
+}
+
+func (t *Table) entryName() string {
+	return strings.TrimSuffix(t.name, "Table") + "Entry"
+}
+
+// SetIndex names the objects that make up the row index in generated MIBs,
+// the first column by default. They may be columns of another table.
+func (t *Table) SetIndex(names ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.index = names
+}
+
+func (t *Table) indexNames() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.index) == 0 && len(t.order) > 0 {
+		return []string{t.columns[t.order[0]].Name}
+	}
+	return append([]string(nil), t.index...)
+}
+
+// Row returns the row at the encoded index, see the Index functions
+func (t *Table) Row(index ...[]int) *Row {
+	var idx []int
+	for _, i := range index {
+		idx = append(idx, i...)
+	}
+	return &Row{table: t, index: idx}
+}
+
+// Row is a row of a Table
+type Row struct {
+	table *Table
+	index []int
+}
+
+// Set adds the value of column id in this row, the value type must match
+// the column
+func (r *Row) Set(id int, value isTypedValue) error {
+	c, ok := r.table.columns[id]
+	if !ok {
+		return fmt.Errorf("unknown column %d", id)
+	}
+	v := typedValue{value}
+	if c.Type != "" && v.TypeString() != c.Type {
+		return fmt.Errorf("column %d is %s, not %s", id, c.Type, v.TypeString())
+	}
+
+	subs := make([]int, 0, len(r.table.subs)+2+len(r.index))
+	subs = append(subs, r.table.subs...)
+	subs = append(subs, 1, id)
+	subs = append(subs, r.index...)
+	return r.table.pp.AddEntry(subs, v)
+}
+
+// SetEnum adds the INTEGER the column's Enum maps s to
+func (r *Row) SetEnum(id int, s string) error {
+	c, ok := r.table.columns[id]
+	if !ok {
+		return fmt.Errorf("unknown column %d", id)
+	}
+	if c.Enum == nil {
+		return fmt.Errorf("column %d has no enum", id)
+	}
+	return r.Set(id, &IntVal{c.Enum.Value(s)})
+}
+
+// AddScalar adds a non-table value, served with the .0 instance suffix
+func (p *PassPersist) AddScalar(subs []int, value isTypedValue) error {
+	s := make([]int, 0, len(subs)+1)
+	s = append(s, subs...)
+	v := typedValue{value}
+	p.addMIBScalar(subs, v.TypeString(), nil)
+	return p.AddEntry(append(s, 0), v)
+}
+
+// IndexInt encodes an INTEGER or Unsigned32 index
+func IndexInt(n int) []int {
+	return []int{n}
+}
+
+// IndexString encodes an OCTET STRING index, prefixed with its length
+func IndexString(s string) []int {
+	idx := make([]int, 0, len(s)+1)
+	idx = append(idx, len(s))
+	for i := 0; i < len(s); i++ {
+		idx = append(idx, int(s[i]))
+	}
+	return idx
+}
+
+// IndexImpliedString encodes an IMPLIED OCTET STRING index, without the
+// length. Only the last index of a table may be IMPLIED.
+func IndexImpliedString(s string) []int {
+	return IndexString(s)[1:]
+}
+
+// IndexIP encodes an IPv4 address as an IpAddress index, its four octets.
+// IPv6 addresses are encoded as an InetAddress, their sixteen octets
+// prefixed with the length, and an invalid address as an empty one.
+func IndexIP(a netip.Addr) []int {
+	a = a.Unmap()
+	if a.Is4() {
+		b := a.As4()
+		return []int{int(b[0]), int(b[1]), int(b[2]), int(b[3])}
+	}
+	if !a.IsValid() {
+		return []int{0}
+	}
+	b := a.As16()
+	idx := make([]int, 0, len(b)+1)
+	idx = append(idx, len(b))
+	for _, o := range b {
+		idx = append(idx, int(o))
+	}
+	return idx
+}
+
+// IndexOID encodes an OBJECT IDENTIFIER index, prefixed with its length
+func IndexOID(o OID) []int {
+	idx := make([]int, 0, len(o.Value)+1)
+	idx = append(idx, len(o.Value))
+	for _, s := range o.Value {
+		idx = append(idx, int(s))
+	}
+	return idx
+}
@@ -0,0 +1,91 @@
+package passpersist
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestIndexEncoding(t *testing.T) {
+	tests := []struct {
+		got  []int
+		want []int
+	}{
+		{IndexInt(7), []int{7}},
+		{IndexString("ab"), []int{2, 97, 98}},
+		{IndexString(""), []int{0}},
+		{IndexImpliedString("ab"), []int{97, 98}},
+		{IndexIP(netip.MustParseAddr("10.0.1.2")), []int{10, 0, 1, 2}},
+		{IndexIP(netip.MustParseAddr("::ffff:10.0.1.2")), []int{10, 0, 1, 2}},
+		{IndexIP(netip.MustParseAddr("2001:db8::1")), []int{16, 32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+		{IndexIP(netip.Addr{}), []int{0}},
+		{IndexOID(MustNewOID("1.3.6")), []int{3, 1, 3, 6}},
+	}
+	for i, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%d: got %v, wanted %v", i, tt.got, tt.want)
+		}
+	}
+}
+
+func TestTableColumnOrder(t *testing.T) {
+	p := NewPassPersist(WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")))
+
+	tbl, err := p.NewTable("testTable", []int{2},
+		Column{ID: 1, Type: "STRING", Name: "testName"},
+		Column{ID: 2, Type: "Counter64", Name: "testCount"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"b", "a"} {
+		row := tbl.Row(IndexString(name))
+		if err := row.Set(1, &StringVal{name}); err != nil {
+			t.Fatal(err)
+		}
+		if err := row.Set(2, &Counter64Val{uint64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.AddScalar([]int{1}, &GaugeVal{2})
+	p.cache.Commit()
+
+	var got []string
+	for _, vb := range p.cache.Walk(p.baseOID, 10) {
+		got = append(got, vb.OID.String())
+	}
+	want := []string{
+		"1.3.6.1.4.1.8072.2.255.1.0",
+		"1.3.6.1.4.1.8072.2.255.2.1.1.1.97",
+		"1.3.6.1.4.1.8072.2.255.2.1.1.1.98",
+		"1.3.6.1.4.1.8072.2.255.2.1.2.1.97",
+		"1.3.6.1.4.1.8072.2.255.2.1.2.1.98",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	if n := DefaultMIBResolver.Name(MustNewOID("1.3.6.1.4.1.8072.2.255.2.1.2.1.97")); n != "testCount.1.97" {
+		t.Errorf("got name %s", n)
+	}
+}
+
+func TestTableErrors(t *testing.T) {
+	p := NewPassPersist(WithBaseOID(MustNewOID("1.3.6.1.4.1.8072.2.255")))
+
+	if _, err := p.NewTable("", []int{1}, Column{ID: 1}, Column{ID: 1}); err == nil {
+		t.Error("expected duplicate column error")
+	}
+	if _, err := p.NewTable("", []int{1}, Column{ID: 0}); err == nil {
+		t.Error("expected invalid column error")
+	}
+
+	tbl, _ := p.NewTable("", []int{1}, Column{ID: 1, Type: "INTEGER"})
+	row := tbl.Row(IndexInt(1))
+	if err := row.Set(2, &IntVal{1}); err == nil {
+		t.Error("expected unknown column error")
+	}
+	if err := row.Set(1, &StringVal{"x"}); err == nil {
+		t.Error("expected type mismatch error")
+	}
+}
@@ -0,0 +1,295 @@
+package passpersist
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+type AuthProtocol string
+
+const (
+	NoAuth     AuthProtocol = ""
+	AuthMD5    AuthProtocol = "MD5"
+	AuthSHA    AuthProtocol = "SHA"
+	AuthSHA224 AuthProtocol = "SHA224"
+	AuthSHA256 AuthProtocol = "SHA256"
+	AuthSHA384 AuthProtocol = "SHA384"
+	AuthSHA512 AuthProtocol = "SHA512"
+)
+
+type PrivProtocol string
+
+const (
+	NoPriv  PrivProtocol = ""
+	PrivDES PrivProtocol = "DES"
+	PrivAES PrivProtocol = "AES"
+)
+
+const (
+	usmFlagAuth       byte = 0x01
+	usmFlagPriv       byte = 0x02
+	usmFlagReportable byte = 0x04
+)
+
+func (a AuthProtocol) hash() func() hash.Hash {
+	switch a {
+	case AuthMD5:
+		return md5.New
+	case AuthSHA:
+		return sha1.New
+	case AuthSHA224:
+		return sha256.New224
+	case AuthSHA256:
+		return sha256.New
+	case AuthSHA384:
+		return sha512.New384
+	case AuthSHA512:
+		return sha512.New
+	}
+	return nil
+}
+
+// digestLen is the truncated HMAC length carried in msgAuthenticationParameters
+// (RFC 3414 and RFC 7860)
+func (a AuthProtocol) digestLen() int {
+	switch a {
+	case AuthMD5, AuthSHA:
+		return 12
+	case AuthSHA224:
+		return 16
+	case AuthSHA256:
+		return 24
+	case AuthSHA384:
+		return 32
+	case AuthSHA512:
+		return 48
+	}
+	return 0
+}
+
+// USMUser is an SNMPv3 user served by the embedded agent
+type USMUser struct {
+	Name         string
+	AuthProtocol AuthProtocol
+	AuthPassword string
+	PrivProtocol PrivProtocol
+	PrivPassword string
+
+	authKey []byte
+	privKey []byte
+}
+
+// ParseUSMUser parses a user in the form "name[:auth:authpass[:priv:privpass]]",
+// for example "admin:SHA:authpass123:AES:privpass123"
+func ParseUSMUser(s string) (USMUser, error) {
+	f := strings.Split(s, ":")
+	u := USMUser{Name: f[0]}
+	if u.Name == "" {
+		return u, errors.New("user name is required")
+	}
+
+	switch len(f) {
+	case 1:
+	case 3, 5:
+		u.AuthProtocol = AuthProtocol(strings.ToUpper(f[1]))
+		u.AuthPassword = f[2]
+		if len(f) == 5 {
+			u.PrivProtocol = PrivProtocol(strings.ToUpper(f[3]))
+			u.PrivPassword = f[4]
+		}
+	default:
+		return u, fmt.Errorf("invalid user '%s'", s)
+	}
+
+	return u, u.validate()
+}
+
+func (u *USMUser) validate() error {
+	if u.AuthProtocol != NoAuth && u.AuthProtocol.hash() == nil {
+		return fmt.Errorf("unsupported auth protocol '%s'", u.AuthProtocol)
+	}
+
+	switch u.PrivProtocol {
+	case NoPriv:
+	case PrivDES, PrivAES:
+		if u.AuthProtocol == NoAuth {
+			return errors.New("privacy requires authentication")
+		}
+	default:
+		return fmt.Errorf("unsupported priv protocol '%s'", u.PrivProtocol)
+	}
+
+	// RFC 3414 Section 11.2
+	if u.AuthProtocol != NoAuth && len(u.AuthPassword) < 8 {
+		return errors.New("auth password must be at least 8 characters")
+	}
+	if u.PrivProtocol != NoPriv && len(u.PrivPassword) < 8 {
+		return errors.New("priv password must be at least 8 characters")
+	}
+
+	return nil
+}
+
+func (u *USMUser) flags() byte {
+	var f byte
+	if u.AuthProtocol != NoAuth {
+		f |= usmFlagAuth
+	}
+	if u.PrivProtocol != NoPriv {
+		f |= usmFlagPriv
+	}
+	return f
+}
+
+// localize derives the keys for the given engine ID
+func (u *USMUser) localize(engineID []byte) {
+	if u.AuthProtocol == NoAuth {
+		return
+	}
+	h := u.AuthProtocol.hash()
+	u.authKey = localizeKey(h, passwordToKey(h, u.AuthPassword), engineID)
+	if u.PrivProtocol != NoPriv {
+		u.privKey = localizeKey(h, passwordToKey(h, u.PrivPassword), engineID)
+	}
+}
+
+// RFC 3414 Appendix A.2
+func passwordToKey(newHash func() hash.Hash, password string) []byte {
+	h := newHash()
+	p := []byte(password)
+	buf := make([]byte, 64)
+	idx := 0
+	for count := 0; count < 1048576; count += 64 {
+		for i := range buf {
+			buf[i] = p[idx%len(p)]
+			idx++
+		}
+		h.Write(buf)
+	}
+	return h.Sum(nil)
+}
+
+func localizeKey(newHash func() hash.Hash, key []byte, engineID []byte) []byte {
+	h := newHash()
+	h.Write(key)
+	h.Write(engineID)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+func (u *USMUser) digest(msg []byte) []byte {
+	m := hmac.New(u.AuthProtocol.hash(), u.authKey)
+	m.Write(msg)
+	return m.Sum(nil)[:u.AuthProtocol.digestLen()]
+}
+
+// authenticate writes the digest of msg at off, the placeholder must be zeroed
+func (u *USMUser) authenticate(msg []byte, off int) {
+	copy(msg[off:], u.digest(msg))
+}
+
+// verify checks the digest found at off without modifying msg
+func (u *USMUser) verify(msg []byte, off int) bool {
+	n := u.AuthProtocol.digestLen()
+	if off < 0 || off+n > len(msg) {
+		return false
+	}
+	c := make([]byte, len(msg))
+	copy(c, msg)
+	for i := off; i < off+n; i++ {
+		c[i] = 0
+	}
+	return hmac.Equal(u.digest(c), msg[off:off+n])
+}
+
+// encrypt returns the cipher text and the msgPrivacyParameters
+func (u *USMUser) encrypt(plain []byte, boots, engineTime int32, salt uint64) ([]byte, []byte, error) {
+	switch u.PrivProtocol {
+	case PrivDES:
+		// RFC 3414 Section 8.1.1.1
+		if len(u.privKey) < 16 {
+			return nil, nil, errors.New("priv key too short for DES")
+		}
+		params := make([]byte, 8)
+		binary.BigEndian.PutUint32(params, uint32(boots))
+		binary.BigEndian.PutUint32(params[4:], uint32(salt))
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = u.privKey[8+i] ^ params[i]
+		}
+		block, err := des.NewCipher(u.privKey[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+		if r := len(plain) % des.BlockSize; r != 0 {
+			plain = append(plain, make([]byte, des.BlockSize-r)...)
+		}
+		out := make([]byte, len(plain))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, plain)
+		return out, params, nil
+	case PrivAES:
+		// RFC 3826 Section 3.1.2.1
+		params := make([]byte, 8)
+		binary.BigEndian.PutUint64(params, salt)
+		block, err := aes.NewCipher(u.privKey[:16])
+		if err != nil {
+			return nil, nil, err
+		}
+		out := make([]byte, len(plain))
+		cipher.NewCFBEncrypter(block, aesIV(boots, engineTime, params)).XORKeyStream(out, plain)
+		return out, params, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported priv protocol '%s'", u.PrivProtocol)
+}
+
+func (u *USMUser) decrypt(data []byte, params []byte, boots, engineTime int32) ([]byte, error) {
+	if len(params) != 8 {
+		return nil, errors.New("invalid privacy parameters")
+	}
+
+	switch u.PrivProtocol {
+	case PrivDES:
+		if len(data)%des.BlockSize != 0 {
+			return nil, errors.New("cipher text is not a multiple of the block size")
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = u.privKey[8+i] ^ params[i]
+		}
+		block, err := des.NewCipher(u.privKey[:8])
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+		return out, nil
+	case PrivAES:
+		block, err := aes.NewCipher(u.privKey[:16])
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(data))
+		cipher.NewCFBDecrypter(block, aesIV(boots, engineTime, params)).XORKeyStream(out, data)
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported priv protocol '%s'", u.PrivProtocol)
+}
+
+func aesIV(boots, engineTime int32, salt []byte) []byte {
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint32(iv, uint32(boots))
+	binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+	copy(iv[8:], salt)
+	return iv
+}
@@ -16,10 +16,25 @@ type VarBind struct {
 	Value     typedValue `json:"value"`
 }
 
+// NewVarBind returns a VarBind for any of the *Val types, e.g. &StringVal{"up"}
+func NewVarBind(oid OID, value isTypedValue) *VarBind {
+	v := typedValue{value}
+	return &VarBind{
+		OID:       oid,
+		ValueType: v.TypeString(),
+		Value:     v,
+	}
+}
+
 func (r *VarBind) String() string {
 	return fmt.Sprintf("%s, %s, %v", r.OID, r.Value.TypeString(), r.Value)
 }
 
+// equalValue reports whether both VarBinds carry the same type and value
+func (r *VarBind) equalValue(o *VarBind) bool {
+	return r.Value.TypeString() == o.Value.TypeString() && r.Value.String() == o.Value.String()
+}
+
 func (r *VarBind) Marshal() string {
 
 	return fmt.Sprintf("%s\n%s\n%s", r.OID, r.Value.TypeString(), r.Value.String())
@@ -56,7 +71,8 @@ func (v *typedValue) String() string {
 		o := v.GetOIDVal()
 		return o.String()
 	case *TimeTicksVal:
-		return v.GetTimeTicksVal().String()
+		// snmpd expects hundredths of a second
+		return strconv.FormatInt(int64(v.GetTimeTicksVal()/(10*time.Millisecond)), 10)
 	default:
 		slog.Warn("unknown value type ", "type", reflect.TypeOf(v.GetValue()).String())
 	}
@@ -0,0 +1,24 @@
+package passpersist
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVarBindMarshal(t *testing.T) {
+	oid := MustNewOID("1.3.6.1.4.1.8072.2.255.1")
+	for _, tt := range []struct {
+		value isTypedValue
+		want  string
+	}{
+		{&StringVal{"up"}, "1.3.6.1.4.1.8072.2.255.1\nSTRING\nup"},
+		{&GaugeVal{7}, "1.3.6.1.4.1.8072.2.255.1\nGAUGE\n7"},
+		// snmpd reads TimeTicks as hundredths of a second
+		{&TimeTicksVal{1234 * time.Millisecond}, "1.3.6.1.4.1.8072.2.255.1\nTIMETICKS\n123"},
+		{&TimeTicksVal{90 * time.Minute}, "1.3.6.1.4.1.8072.2.255.1\nTIMETICKS\n540000"},
+	} {
+		if got := NewVarBind(oid, tt.value).Marshal(); got != tt.want {
+			t.Errorf("got %q, wanted %q", got, tt.want)
+		}
+	}
+}
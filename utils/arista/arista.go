@@ -2,6 +2,7 @@ package arista
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,10 +15,42 @@ import (
 	"github.com/go-cmd/cmd"
 )
 
+// MaxOutputSize caps the bytes of output read from a command, zero reads
+// everything. Past it the command is stopped and ErrOutputTooLarge
+// returned, so a runaway show command cannot exhaust the switch's memory.
+var MaxOutputSize int64
+
+// ErrOutputTooLarge is returned for output exceeding MaxOutputSize
+var ErrOutputTooLarge = errors.New("command output exceeds the size limit")
+
 func EosCommand(command string) ([]string, error) {
-	c := cmd.NewCmd("Cli", "-p15", "-c", command)
+	return EosCommandContext(context.Background(), command)
+}
+
+// EosCommandContext is like EosCommand but stops the Cli process when ctx
+// is done
+func EosCommandContext(ctx context.Context, command string) ([]string, error) {
+	return runCommand(ctx, MaxOutputSize, "Cli", "-p15", "-c", command)
+}
+
+func runCommand(ctx context.Context, max int64, name string, args ...string) ([]string, error) {
+	if max > 0 {
+		return runCommandLimited(ctx, max, name, args...)
+	}
+
+	c := cmd.NewCmd(name, args...)
 	c.Env = append(c.Env, "TERM=dumb")
-	<-c.Start()
+
+	select {
+	case <-c.Start():
+	case <-ctx.Done():
+		c.Stop()
+		return []string{}, ctx.Err()
+	}
+
+	if err := c.Status().Error; err != nil {
+		return []string{}, err
+	}
 
 	stderr := c.Status().Stderr
 	if len(stderr) > 0 {
@@ -27,8 +60,86 @@ func EosCommand(command string) ([]string, error) {
 	return c.Status().Stdout, nil
 }
 
+// maxLineBuffer bounds the line buffers go-cmd allocates up front for
+// stdout and stderr
+const maxLineBuffer = 1 << 20
+
+// runCommandLimited streams the output instead of buffering it in go-cmd,
+// counting the bytes as they arrive
+func runCommandLimited(ctx context.Context, max int64, name string, args ...string) ([]string, error) {
+	lineBuffer := max
+	if lineBuffer > maxLineBuffer {
+		lineBuffer = maxLineBuffer
+	}
+	c := cmd.NewCmdOptions(cmd.Options{Streaming: true, LineBufferSize: uint(lineBuffer)}, name, args...)
+	c.Env = append(c.Env, "TERM=dumb")
+	statusc := c.Start()
+
+	stop := func(err error) ([]string, error) {
+		c.Stop()
+		// the streams block until read, drain them so the command can exit
+		go func() {
+			for range c.Stdout {
+			}
+		}()
+		go func() {
+			for range c.Stderr {
+			}
+		}()
+		return []string{}, err
+	}
+
+	var stdout, stderr []string
+	var size int64
+	outc, errc := c.Stdout, c.Stderr
+	for outc != nil || errc != nil {
+		select {
+		case l, ok := <-outc:
+			if !ok {
+				outc = nil
+				break
+			}
+			size += int64(len(l)) + 1
+			if size > max {
+				slog.Warn("command output too large", "command", name, "limit", max)
+				return stop(ErrOutputTooLarge)
+			}
+			stdout = append(stdout, l)
+		case l, ok := <-errc:
+			if !ok {
+				errc = nil
+				break
+			}
+			size += int64(len(l)) + 1
+			if size > max {
+				return stop(ErrOutputTooLarge)
+			}
+			stderr = append(stderr, l)
+		case <-ctx.Done():
+			return stop(ctx.Err())
+		}
+	}
+
+	status := <-statusc
+	if status.Error != nil {
+		if lineBuffer == max && errors.As(status.Error, new(cmd.ErrLineBufferOverflow)) {
+			// a single line longer than the limit
+			return []string{}, ErrOutputTooLarge
+		}
+		return []string{}, status.Error
+	}
+	if len(stderr) > 0 {
+		return []string{}, fmt.Errorf("%s", strings.Join(stderr, "\n"))
+	}
+	return stdout, nil
+}
+
 func EosCommandJson(command string, v any) error {
-	out, err := EosCommand(fmt.Sprintf("%s | json", command))
+	return EosCommandJsonContext(context.Background(), command, v)
+}
+
+func EosCommandJsonContext(ctx context.Context, command string, v any) error {
+	out, err := EosCommandContext(ctx, fmt.Sprintf("%s | json", command))
 	if err != nil {
 		return err
 	}
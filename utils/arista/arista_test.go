@@ -1 +1,38 @@
 package arista
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRunCommandLimited(t *testing.T) {
+	tests := []struct {
+		script string
+		want   []string
+		err    error
+	}{
+		{"printf 'a\\nb\\n'", []string{"a", "b"}, nil},
+		{"yes | head -n 10000", nil, ErrOutputTooLarge},
+		{"head -c 2000 /dev/zero | tr '\\0' a", nil, ErrOutputTooLarge},
+	}
+	for _, tt := range tests {
+		got, err := runCommand(context.Background(), 1000, "sh", "-c", tt.script)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, wanted %v", tt.script, err, tt.err)
+			continue
+		}
+		if tt.err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, wanted %q", tt.script, got, tt.want)
+		}
+	}
+}
+
+func TestRunCommandLimitedCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := runCommand(ctx, 1000, "sleep", "10"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v", err)
+	}
+}
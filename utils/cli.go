@@ -2,30 +2,42 @@ package utils
 
 import (
 	"flag"
+	"os"
 
 	"log/slog"
 
+	"github.com/arista-northwest/go-passpersist/utils/config"
 	"github.com/arista-northwest/go-passpersist/utils/logger"
 )
 
-func CommonCLI(version string, tag string, buildDate string) {
+// CommonCLI parses the command line and returns the merged configuration,
+// invalid settings are fatal
+func CommonCLI(version string, tag string, buildDate string) *config.Config {
+	cfg := config.New()
+	cfg.RegisterFlags(flag.CommandLine)
+
 	ver := flag.Bool("v", false, "display version")
 	debug := flag.Bool("debug", false, "override extension logging and enable console debugging")
-	console := flag.Bool("console", false, "enable console logging")
-	level := flag.String("level", "INFO", "set logging level")
 	flag.Parse()
 
 	if *ver {
 		DisplayVersionAndExit(version, buildDate, tag)
 	}
 
+	err := cfg.Load()
+
 	if *debug {
 		logger.EnableConsoleLogger(slog.LevelDebug, true)
+	} else if cfg.Console {
+		logger.EnableConsoleLogger(cfg.LogLevel, false)
+	} else if cfg.Source("log-level") != "" {
+		logger.SetSyslogLevel(cfg.LogLevel)
 	}
 
-	if *console {
-		var l slog.Level
-		l.UnmarshalText([]byte(*level))
-		logger.EnableConsoleLogger(l, false)
+	if err != nil {
+		slog.Error("invalid configuration", slog.Any("error", err))
+		os.Exit(1)
 	}
+
+	return cfg
 }
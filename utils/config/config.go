@@ -0,0 +1,728 @@
+// Package config merges extension settings from defaults, a config file,
+// PASSPERSIST_* env vars and command line flags.
+//
+// Later sources win: defaults < file < env < flags. The file is named by
+// the -config flag or PASSPERSIST_CONFIG and may be JSON or a flat YAML
+// mapping. Every key can be set from any source, e.g. refresh-rate is
+// "refresh-rate" in the file, PASSPERSIST_REFRESH_RATE in the env and
+// -refresh-rate on the command line.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arista-northwest/go-passpersist/passpersist"
+)
+
+const envPrefix = "PASSPERSIST_"
+
+// Sources a value can come from, lowest precedence first
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+type key struct {
+	name    string
+	alias   string
+	usage   string
+	secret  bool
+	boolean bool
+	set     func(c *Config, v string) error
+}
+
+// keys are applied in this order, mib-dirs comes first so base-oid may use
+// names from it
+var keys = []key{
+	{name: "mib-dirs", usage: "colon separated directories of MIB modules", set: func(c *Config, v string) error {
+		c.MIBDirs = filepath.SplitList(v)
+		for _, d := range c.MIBDirs {
+			if err := passpersist.DefaultMIBResolver.LoadDir(d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{name: "base-oid", usage: "base OID, numeric or a MIB name", set: func(c *Config, v string) error {
+		o, err := passpersist.NewOID(v)
+		if err != nil {
+			return err
+		}
+		c.BaseOID = &o
+		return nil
+	}},
+	{name: "refresh-rate", usage: "time between refreshes, e.g. 60s", set: func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return errors.New("must be positive")
+		}
+		c.RefreshRate = d
+		return nil
+	}},
+	{name: "lazy", usage: "refresh on demand when polled values are older than this, e.g. 30s, instead of every refresh-rate", set: func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return errors.New("must not be negative")
+		}
+		c.Lazy = d
+		return nil
+	}},
+	{name: "lazy-deadline", usage: "how long a request waits for an on demand refresh", set: func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return errors.New("must be positive")
+		}
+		c.LazyDeadline = d
+		return nil
+	}},
+	{name: "jitter", usage: "delay the first refresh by a random duration up to this", set: func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return errors.New("must not be negative")
+		}
+		c.Jitter = d
+		return nil
+	}},
+	{name: "align", boolean: true, usage: "refresh on wall clock multiples of refresh-rate", set: func(c *Config, v string) (err error) {
+		c.Align, err = strconv.ParseBool(v)
+		return err
+	}},
+	{name: "backoff", usage: "double the time to the next refresh after each failure, up to this", set: func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return errors.New("must not be negative")
+		}
+		c.Backoff = d
+		return nil
+	}},
+	{name: "max-concurrent-refresh", usage: "let at most this many collectors, subtrees included, refresh at once", set: func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errors.New("must not be negative")
+		}
+		c.MaxConcurrentRefresh = n
+		return nil
+	}},
+	{name: "snapshot", usage: "save values to this file and serve them at startup until the first refresh", set: func(c *Config, v string) error {
+		c.Snapshot = v
+		return nil
+	}},
+	{name: "snapshot-max-age", usage: "ignore snapshots older than this at startup", set: func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return errors.New("must not be negative")
+		}
+		c.SnapshotMaxAge = d
+		return nil
+	}},
+	{name: "strict", boolean: true, usage: "reject conflicting values and report them per refresh", set: func(c *Config, v string) (err error) {
+		c.Strict, err = strconv.ParseBool(v)
+		return err
+	}},
+	{name: "health", usage: "serve refresh statistics at these sub-ids below the base OID, e.g. 99", set: func(c *Config, v string) error {
+		subs, err := parseSubs(v)
+		if err != nil {
+			return err
+		}
+		c.Health = subs
+		return nil
+	}},
+	{name: "log-level", alias: "level", usage: "DEBUG, INFO, WARN or ERROR", set: func(c *Config, v string) error {
+		return c.LogLevel.UnmarshalText([]byte(v))
+	}},
+	{name: "console", boolean: true, usage: "log to the console instead of syslog", set: func(c *Config, v string) (err error) {
+		c.Console, err = strconv.ParseBool(v)
+		return
+	}},
+	{name: "agent-addr", usage: "serve SNMP on host:port instead of pass_persist", set: func(c *Config, v string) error {
+		if _, _, err := net.SplitHostPort(v); err != nil {
+			return err
+		}
+		c.AgentAddr = v
+		return nil
+	}},
+	{name: "agent-community", usage: "community accepted by the agent", secret: true, set: func(c *Config, v string) error {
+		c.AgentCommunity = v
+		return nil
+	}},
+	{name: "agent-users", usage: "comma separated name:auth:authpass:priv:privpass", secret: true, set: func(c *Config, v string) error {
+		c.AgentUsers = nil
+		for _, s := range strings.Split(v, ",") {
+			u, err := passpersist.ParseUSMUser(s)
+			if err != nil {
+				return err
+			}
+			c.AgentUsers = append(c.AgentUsers, u)
+		}
+		return nil
+	}},
+	{name: "metrics-addr", usage: "serve OpenMetrics on http://host:port/metrics", set: func(c *Config, v string) error {
+		if _, _, err := net.SplitHostPort(v); err != nil {
+			return err
+		}
+		c.MetricsAddr = v
+		return nil
+	}},
+	{name: "debug-addr", usage: "serve the JSON debug API on a unix socket path or loopback host:port", set: func(c *Config, v string) error {
+		if !strings.HasPrefix(v, "/") {
+			if _, _, err := net.SplitHostPort(v); err != nil {
+				return err
+			}
+		}
+		c.DebugAddr = v
+		return nil
+	}},
+	{name: "debug-commands", boolean: true, usage: "enable test commands such as PANIC", set: func(c *Config, v string) (err error) {
+		c.DebugCommands, err = strconv.ParseBool(v)
+		return err
+	}},
+	{name: "diag-dir", usage: "write profiles and runtime diagnostics to this directory", set: func(c *Config, v string) error {
+		c.DiagDir = v
+		return nil
+	}},
+	{name: "memory-limit", usage: "soft memory limit, e.g. 64MiB, refreshes are skipped above it", set: func(c *Config, v string) (err error) {
+		c.MemoryLimit, err = parseSize(v)
+		return err
+	}},
+	{name: "max-procs", usage: "cap GOMAXPROCS to this", set: func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errors.New("must not be negative")
+		}
+		c.MaxProcs = n
+		return nil
+	}},
+	{name: "max-entries", usage: "cap the values a refresh may add", set: func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errors.New("must not be negative")
+		}
+		c.MaxEntries = n
+		return nil
+	}},
+	{name: "max-entries-policy", usage: "drop the values past max-entries, or fail the refresh", set: func(c *Config, v string) error {
+		switch strings.ToLower(v) {
+		case "drop":
+			c.MaxEntriesPolicy = passpersist.DropExcess
+		case "fail":
+			c.MaxEntriesPolicy = passpersist.FailRefresh
+		default:
+			return errors.New("must be drop or fail")
+		}
+		return nil
+	}},
+	{name: "max-output-size", usage: "cap the output read from an EOS command, e.g. 16MiB", set: func(c *Config, v string) (err error) {
+		c.MaxOutputSize, err = parseSize(v)
+		return err
+	}},
+	{name: "trap-target", usage: "send v2c traps to host:port", set: func(c *Config, v string) error {
+		if _, _, err := net.SplitHostPort(v); err != nil {
+			return err
+		}
+		c.TrapTarget = v
+		return nil
+	}},
+	{name: "trap-community", usage: "community used for traps", secret: true, set: func(c *Config, v string) error {
+		c.TrapCommunity = v
+		return nil
+	}},
+}
+
+// sizeUnits are the suffixes parseSize accepts, longest first
+var sizeUnits = []struct {
+	suffix string
+	n      int64
+}{
+	{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+}
+
+// parseSubs parses dotted sub-ids relative to the base OID, e.g. 99.1
+func parseSubs(v string) ([]int, error) {
+	var subs []int
+	for _, f := range strings.Split(strings.Trim(v, "."), ".") {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errors.New("must not be negative")
+		}
+		subs = append(subs, n)
+	}
+	return subs, nil
+}
+
+// parseSize parses a byte count such as 1048576, 1024KiB or 1M
+func parseSize(v string) (int64, error) {
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			v, mult = strings.TrimSuffix(v, u.suffix), u.n
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, errors.New("must not be negative")
+	}
+	return n * mult, nil
+}
+
+func lookupKey(name string) (key, bool) {
+	for _, k := range keys {
+		if k.name == name {
+			return k, true
+		}
+	}
+	return key{}, false
+}
+
+// EnvName returns the env var for a key, e.g. PASSPERSIST_REFRESH_RATE
+func EnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Config is the merged configuration, zero values mean not set
+type Config struct {
+	BaseOID              *passpersist.OID
+	RefreshRate          time.Duration
+	Lazy                 time.Duration
+	LazyDeadline         time.Duration
+	Jitter               time.Duration
+	Align                bool
+	Backoff              time.Duration
+	MaxConcurrentRefresh int
+	Snapshot             string
+	SnapshotMaxAge       time.Duration
+	Strict               bool
+	Health               []int
+	LogLevel             slog.Level
+	Console              bool
+	MIBDirs              []string
+	AgentAddr            string
+	AgentCommunity       string
+	AgentUsers           []passpersist.USMUser
+	MetricsAddr          string
+	DebugAddr            string
+	DebugCommands        bool
+	DiagDir              string
+	MemoryLimit          int64
+	MaxProcs             int
+	MaxEntries           int
+	MaxEntriesPolicy     passpersist.LimitPolicy
+	MaxOutputSize        int64
+	TrapTarget           string
+	TrapCommunity        string
+
+	path    string
+	raw     map[string]string
+	sources map[string]string
+	flags   map[string]string
+}
+
+// New returns a Config holding the defaults
+func New() *Config {
+	c := &Config{
+		raw:     make(map[string]string),
+		sources: make(map[string]string),
+		flags:   make(map[string]string),
+	}
+	c.SetDefault("refresh-rate", passpersist.DefaultRefreshRate.String())
+	c.SetDefault("log-level", slog.LevelInfo.String())
+	return c
+}
+
+// SetDefault changes the default of a key, e.g. an extension that
+// refreshes every 5 minutes or a base OID found in snmpd.conf
+func (c *Config) SetDefault(name string, value string) error {
+	if s, ok := c.sources[name]; ok && s != SourceDefault {
+		return nil
+	}
+	return c.set(name, value, SourceDefault)
+}
+
+func (c *Config) set(name string, value string, source string) error {
+	k, ok := lookupKey(name)
+	if !ok {
+		return fmt.Errorf("%s: unknown setting %q", source, name)
+	}
+	if err := k.set(c, value); err != nil {
+		return fmt.Errorf("%s: invalid %s %q: %w", source, name, value, err)
+	}
+	c.raw[name] = value
+	c.sources[name] = source
+	return nil
+}
+
+type flagValue struct {
+	c       *Config
+	name    string
+	boolean bool
+}
+
+func (f flagValue) IsBoolFlag() bool {
+	return f.boolean
+}
+
+func (f flagValue) String() string {
+	if f.c == nil {
+		return ""
+	}
+	return f.c.flags[f.name]
+}
+
+func (f flagValue) Set(v string) error {
+	f.c.flags[f.name] = v
+	return nil
+}
+
+// RegisterFlags adds -config and a flag per key to fs. Flag values are
+// applied last by Load.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.path, "config", "", "load settings from a JSON or YAML file")
+	for _, k := range keys {
+		fs.Var(flagValue{c, k.name, k.boolean}, k.name, k.usage)
+		if k.alias != "" {
+			fs.Var(flagValue{c, k.name, k.boolean}, k.alias, "alias for -"+k.name)
+		}
+	}
+}
+
+// Load applies the config file, the env and any flags given, in that order.
+// All invalid values are reported in the returned error.
+func (c *Config) Load() error {
+	var errs []error
+
+	path := c.path
+	if path == "" {
+		path = os.Getenv(EnvName("config"))
+	}
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		errs = append(errs, c.apply(values, SourceFile)...)
+	}
+
+	env := make(map[string]string)
+	for _, k := range keys {
+		if v, ok := os.LookupEnv(EnvName(k.name)); ok {
+			env[k.name] = v
+		}
+	}
+	errs = append(errs, c.apply(env, SourceEnv)...)
+	errs = append(errs, c.apply(c.flags, SourceFlag)...)
+
+	return errors.Join(errs...)
+}
+
+// apply sets values in key order
+func (c *Config) apply(values map[string]string, source string) []error {
+	var errs []error
+	for name := range values {
+		if _, ok := lookupKey(name); !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", source, name))
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+
+	for _, k := range keys {
+		if v, ok := values[k.name]; ok {
+			if err := c.set(k.name, v, source); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+// Source returns where the value of a key came from, empty if unset
+func (c *Config) Source(name string) string {
+	return c.sources[name]
+}
+
+// Options returns the passpersist options for the merged values. The env
+// was already applied so the options turn off passpersist's own env
+// override.
+func (c *Config) Options() []passpersist.Option {
+	opts := []passpersist.Option{
+		passpersist.WithEnvOverride(false),
+		passpersist.WithConfigDump(c),
+	}
+
+	if c.BaseOID != nil {
+		opts = append(opts, passpersist.WithBaseOID(*c.BaseOID))
+	}
+	opts = append(opts, passpersist.WithRefresh(c.RefreshRate))
+	if c.Lazy > 0 {
+		opts = append(opts, passpersist.WithLazy(c.Lazy, c.LazyDeadline))
+	}
+	if c.Jitter > 0 {
+		opts = append(opts, passpersist.WithJitter(c.Jitter))
+	}
+	if c.Align {
+		opts = append(opts, passpersist.WithAlignedRefresh())
+	}
+	if c.Backoff > 0 {
+		opts = append(opts, passpersist.WithBackoff(c.Backoff))
+	}
+	if c.MaxConcurrentRefresh > 0 {
+		opts = append(opts, passpersist.WithMaxConcurrentRefresh(c.MaxConcurrentRefresh))
+	}
+	if c.Snapshot != "" {
+		opts = append(opts, passpersist.WithSnapshot(c.Snapshot, c.SnapshotMaxAge))
+	}
+	if c.Strict {
+		opts = append(opts, passpersist.WithStrict(false))
+	}
+	if len(c.Health) > 0 {
+		opts = append(opts, passpersist.WithHealth(c.Health))
+	}
+	if c.MetricsAddr != "" {
+		opts = append(opts, passpersist.WithMetrics(c.MetricsAddr))
+	}
+	if c.DebugAddr != "" {
+		opts = append(opts, passpersist.WithDebugAddr(c.DebugAddr))
+	}
+	if c.DebugCommands {
+		opts = append(opts, passpersist.WithDebug())
+	}
+	if c.DiagDir != "" {
+		opts = append(opts, passpersist.WithDiagDir(c.DiagDir))
+	}
+	if c.MemoryLimit > 0 {
+		opts = append(opts, passpersist.WithMemoryLimit(c.MemoryLimit))
+	}
+	if c.MaxProcs > 0 {
+		opts = append(opts, passpersist.WithMaxProcs(c.MaxProcs))
+	}
+	if c.MaxEntries > 0 {
+		opts = append(opts, passpersist.WithMaxEntries(c.MaxEntries, c.MaxEntriesPolicy))
+	}
+
+	if c.AgentAddr != "" {
+		var aopts []passpersist.AgentOption
+		if c.AgentCommunity != "" {
+			aopts = append(aopts, passpersist.WithCommunity(c.AgentCommunity))
+		}
+		for _, u := range c.AgentUsers {
+			aopts = append(aopts, passpersist.WithUSMUser(u))
+		}
+		opts = append(opts, passpersist.WithAgent(c.AgentAddr, aopts...))
+	}
+
+	if c.TrapTarget != "" {
+		var topts []passpersist.UDPSenderOption
+		if c.TrapCommunity != "" {
+			topts = append(topts, passpersist.WithTrapCommunity(c.TrapCommunity))
+		}
+		s := passpersist.NewUDPSender(c.TrapTarget, topts...)
+		opts = append(opts, passpersist.WithNotificationSender(
+			passpersist.Dedup(passpersist.RateLimit(s, time.Second, 10), time.Minute)))
+	}
+
+	return opts
+}
+
+// MarshalJSON shows each value with its source, secrets are masked
+func (c *Config) MarshalJSON() ([]byte, error) {
+	type entry struct {
+		Value  string `json:"value"`
+		Source string `json:"source"`
+	}
+	m := make(map[string]entry, len(c.raw))
+	for _, k := range keys {
+		v, ok := c.raw[k.name]
+		if !ok {
+			continue
+		}
+		if k.secret {
+			v = "********"
+		}
+		m[k.name] = entry{Value: v, Source: c.sources[k.name]}
+	}
+	return json.Marshal(m)
+}
+
+// readFile loads a JSON object or a flat YAML mapping as strings, lists
+// are joined the same way they are written in the env
+func readFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return parseYAML(path, b)
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		s, err := jsonString(k, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		out[k] = s
+	}
+	return out, nil
+}
+
+func jsonString(name string, v any) (string, error) {
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	case []any:
+		parts := make([]string, len(x))
+		for i, e := range x {
+			s, err := jsonString(name, e)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, listSep(name)), nil
+	}
+	return "", fmt.Errorf("unsupported value for %s: %v", name, v)
+}
+
+// listSep is the separator a key uses for lists in the env
+func listSep(name string) string {
+	if name == "mib-dirs" {
+		return string(filepath.ListSeparator)
+	}
+	return ","
+}
+
+// parseYAML understands "key: value" lines and block lists of scalars,
+// which is all the settings need
+func parseYAML(path string, b []byte) (map[string]string, error) {
+	out := make(map[string]string)
+	var list string
+	var items []string
+
+	flush := func() {
+		if list != "" {
+			out[list] = strings.Join(items, listSep(list))
+		}
+		list, items = "", nil
+	}
+
+	for n, line := range strings.Split(string(b), "\n") {
+		line = stripComment(line)
+		t := strings.TrimSpace(line)
+		if t == "" || t[0] == '#' || t == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(t, "- ") && list != "" {
+			items = append(items, unquote(strings.TrimSpace(t[2:])))
+			continue
+		}
+		flush()
+
+		k, v, ok := strings.Cut(t, ":")
+		if !ok || line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("%s:%d: expected 'key: value'", path, n+1)
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if v == "" {
+			list = k
+			continue
+		}
+		if strings.HasPrefix(v, "[") && strings.HasSuffix(v, "]") {
+			var parts []string
+			for _, p := range strings.Split(v[1:len(v)-1], ",") {
+				parts = append(parts, unquote(strings.TrimSpace(p)))
+			}
+			v = strings.Join(parts, listSep(k))
+		} else {
+			v = unquote(v)
+		}
+		out[k] = v
+	}
+	flush()
+
+	return out, nil
+}
+
+// stripComment cuts a " #" comment off line, unless it is inside a quoted
+// value
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		space := i > 0 && (line[i-1] == ' ' || line[i-1] == '\t')
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && space:
+			quote = c
+		case c == '#' && space:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
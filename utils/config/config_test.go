@@ -0,0 +1,185 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func load(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+	c := New()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return c, c.Load()
+}
+
+func TestPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"refresh-rate": "10s", "base-oid": "1.3.6.1.3.53", "log-level": "WARN", "agent-users": ["a", "b:SHA:authpass123"]}`), 0600)
+
+	t.Setenv("PASSPERSIST_CONFIG", path)
+	t.Setenv("PASSPERSIST_REFRESH_RATE", "20s")
+	t.Setenv("PASSPERSIST_LOG_LEVEL", "ERROR")
+
+	c, err := load(t, "-level", "DEBUG", "-console")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.BaseOID == nil || c.BaseOID.String() != "1.3.6.1.3.53" || c.Source("base-oid") != SourceFile {
+		t.Errorf("base-oid should come from the file: %v", c.BaseOID)
+	}
+	if c.RefreshRate != 20*time.Second || c.Source("refresh-rate") != SourceEnv {
+		t.Errorf("refresh-rate should come from the env: %s", c.RefreshRate)
+	}
+	if c.LogLevel.String() != "DEBUG" || c.Source("log-level") != SourceFlag {
+		t.Errorf("log-level should come from the flags: %s", c.LogLevel)
+	}
+	if !c.Console || len(c.AgentUsers) != 2 {
+		t.Errorf("unexpected config %+v", c)
+	}
+
+	// defaults set by the extension do not override loaded values
+	c.SetDefault("refresh-rate", "300s")
+	if c.RefreshRate != 20*time.Second {
+		t.Errorf("default replaced a value from the env")
+	}
+}
+
+func TestYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(strings.Join([]string{
+		"# extension settings",
+		"base-oid: ARISTA-SMI-MIB::aristaExperiment.226",
+		"refresh-rate: '5m' # slow",
+		"lazy: 30s",
+		"agent-addr: 127.0.0.1:1161",
+		"agent-users:",
+		"  - admin:SHA:authpass123",
+		"  - monitor",
+	}, "\n")), 0600)
+
+	c, err := load(t, "-config", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BaseOID.String() != "1.3.6.1.4.1.30065.4.226" || c.RefreshRate != 5*time.Minute {
+		t.Errorf("unexpected values %s %s", c.BaseOID, c.RefreshRate)
+	}
+	if c.Lazy != 30*time.Second {
+		t.Errorf("unexpected lazy setting %s", c.Lazy)
+	}
+	if c.AgentAddr != "127.0.0.1:1161" || len(c.AgentUsers) != 2 || c.AgentUsers[1].Name != "monitor" {
+		t.Errorf("unexpected agent settings %+v", c)
+	}
+}
+
+func TestYAMLQuotedHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(strings.Join([]string{
+		`agent-community: "pub #1" # read only`,
+		`trap-community: 'traps #2'`,
+	}, "\n")), 0600)
+
+	c, err := load(t, "-config", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.AgentCommunity != "pub #1" || c.TrapCommunity != "traps #2" {
+		t.Errorf("unexpected communities %q %q", c.AgentCommunity, c.TrapCommunity)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	t.Setenv("PASSPERSIST_REFRESH_RATE", "soon")
+	t.Setenv("PASSPERSIST_BASE_OID", "nosuchObject.1")
+
+	_, err := load(t, "-agent-addr", "localhost", "-trap-target", "10.0.0.1:162")
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, s := range []string{"refresh-rate", "base-oid", "agent-addr"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error does not mention %s: %s", s, err)
+		}
+	}
+	if strings.Contains(err.Error(), "trap-target") {
+		t.Errorf("valid trap-target reported: %s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"refresh": "10s"}`), 0600)
+	os.Unsetenv("PASSPERSIST_REFRESH_RATE")
+	os.Unsetenv("PASSPERSIST_BASE_OID")
+	if _, err := load(t, "-config", path); err == nil || !strings.Contains(err.Error(), `unknown setting "refresh"`) {
+		t.Errorf("expected an unknown setting error, got %v", err)
+	}
+}
+
+func TestDumpMasksSecrets(t *testing.T) {
+	c, err := load(t, "-agent-community", "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "s3cret") || !strings.Contains(string(b), `"source":"flag"`) {
+		t.Errorf("unexpected dump %s", b)
+	}
+}
+
+func TestFlagsLeaveCLIFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	New().RegisterFlags(fs)
+	// defined by utils.CommonCLI
+	for _, name := range []string{"v", "debug"} {
+		if fs.Lookup(name) != nil {
+			t.Errorf("config key %s clashes with a CLI flag", name)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"1048576": 1 << 20,
+		"1024KiB": 1 << 20,
+		"64MiB":   64 << 20,
+		"1G":      1 << 30,
+		"512B":    512,
+	}
+	for in, want := range tests {
+		if got, err := parseSize(in); err != nil || got != want {
+			t.Errorf("%s: got %d, %v, wanted %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "MiB", "-1", "1TB"} {
+		if _, err := parseSize(in); err == nil {
+			t.Errorf("%s accepted", in)
+		}
+	}
+}
+
+func TestHealthAndStrict(t *testing.T) {
+	c, err := load(t, "-health", "99.1", "-strict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Health, []int{99, 1}) || !c.Strict {
+		t.Errorf("got health %v and strict %v", c.Health, c.Strict)
+	}
+
+	if _, err := load(t, "-health", "99.x"); err == nil || !strings.Contains(err.Error(), "health") {
+		t.Errorf("expected a health error, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/arista-northwest/go-passpersist/passpersist"
+	"github.com/arista-northwest/go-passpersist/utils/arista"
+)
+
+// RunExtension runs the registered extension name as a standalone binary
+func RunExtension(name string, version string, tag string, date string) {
+	runExtension(name, false, version, tag, date)
+}
+
+// Multicall runs the extension named by argv[0], so the binary can be
+// symlinked once per extension, or by the first argument,
+// e.g. 'passpersist vrf -console'
+func Multicall(version string, tag string, date string) {
+	if _, ok := passpersist.LookupExtension(ProgName()); ok {
+		runExtension(ProgName(), true, version, tag, date)
+		return
+	}
+
+	if len(os.Args) > 1 {
+		if _, ok := passpersist.LookupExtension(os.Args[1]); ok {
+			name := os.Args[1]
+			os.Args = append(os.Args[:1:1], os.Args[2:]...)
+			runExtension(name, true, version, tag, date)
+			return
+		}
+		if os.Args[1] == "-v" {
+			DisplayVersionAndExit(version, date, tag)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "usage: %s EXTENSION [flags]\n\nextensions: %s\n",
+		ProgName(), strings.Join(passpersist.Extensions(), ", "))
+	os.Exit(2)
+}
+
+func runExtension(name string, multicall bool, version string, tag string, date string) {
+	if code := runExtensionStatus(name, multicall, version, tag, date); code != passpersist.ExitOK {
+		os.Exit(code)
+	}
+}
+
+func runExtensionStatus(name string, multicall bool, version string, tag string, date string) int {
+	defer CapPanic()
+
+	ext, ok := passpersist.LookupExtension(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown extension: %s\n", name)
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if ext.Flags != nil {
+		ext.Flags(flag.CommandLine)
+	}
+	cfg := CommonCLI(version, tag, date)
+
+	var b *passpersist.OID
+	if multicall {
+		b, _ = GetBaseOIDForExtension(name)
+	} else {
+		b, _ = GetBaseOIDFromSNMPdConfig()
+	}
+	if b != nil {
+		if err := cfg.SetDefault("base-oid", b.String()); err != nil {
+			slog.Error("invalid base OID default", slog.Any("error", err))
+			return passpersist.ExitError
+		}
+	}
+	if ext.Refresh > 0 {
+		if err := cfg.SetDefault("refresh-rate", ext.Refresh.String()); err != nil {
+			slog.Error("invalid refresh rate default", slog.Any("error", err))
+			return passpersist.ExitError
+		}
+	}
+
+	arista.MaxOutputSize = cfg.MaxOutputSize
+
+	pp := passpersist.NewPassPersist(cfg.Options()...)
+	if ext.Setup != nil {
+		ext.Setup(pp)
+	}
+
+	return pp.Run(ctx, ext.Collect)
+}
@@ -24,6 +24,17 @@ func EnableSyslogger(prio syslog.Priority, lvl slog.Leveler) error {
 	return nil
 }
 
+// SetSyslogLevel changes the level of the default logger if it logs to
+// syslog, it is meant to be called at startup once the configuration is
+// loaded
+func SetSyslogLevel(lvl slog.Leveler) bool {
+	h, ok := slog.Default().Handler().(*SyslogHandler)
+	if ok {
+		h.lvl = lvl
+	}
+	return ok
+}
+
 // func EnableConsoleDebugLogger) {
 // 	EnableConsoleLogging(slog.LevelDebug, true)
 // }
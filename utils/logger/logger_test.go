@@ -70,3 +70,23 @@ func Test_Formatting(t *testing.T) {
 		}
 	}
 }
+
+func TestSetSyslogLevel(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	cs := &captureStream{}
+	slog.SetDefault(slog.New(NewSyslogHandler(WithWriter(cs))))
+	if !SetSyslogLevel(slog.LevelWarn) {
+		t.Fatal("level not set on the syslog handler")
+	}
+	slog.Info("dropped")
+	slog.Warn("logged")
+	if len(cs.lines) != 1 || !strings.Contains(string(cs.lines[0]), "logged") {
+		t.Errorf("got %q", cs.lines)
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(cs, nil)))
+	if SetSyslogLevel(slog.LevelWarn) {
+		t.Error("level set on a text handler")
+	}
+}
@@ -0,0 +1,295 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/arista-northwest/go-passpersist/passpersist"
+	"github.com/arista-northwest/go-passpersist/utils/arista"
+)
+
+// SNMPdConfigPaths are searched in order for snmpd.conf when SNMPCONFPATH
+// is not set
+var SNMPdConfigPaths = []string{
+	"/etc/snmp/snmpd.conf",
+	"/etc/snmp/snmpd.local.conf",
+	"/usr/share/snmp/snmpd.conf",
+	"/usr/local/etc/snmp/snmpd.conf",
+}
+
+// EOS keeps relative extension paths and flash: URLs under here
+const eosFlashDir = "/mnt/flash"
+
+// max nesting of includeFile/includeDir
+const maxIncludeDepth = 8
+
+// Extension is a pass or pass_persist registration found in snmpd.conf or
+// in the EOS running-config
+type Extension struct {
+	Persist  bool
+	Priority int
+	OID      passpersist.OID
+	Program  string
+	Args     []string
+	// Source is file:line, or running-config for EOS
+	Source string
+}
+
+// ParseSNMPdConfig returns the extensions in the snmpd.conf at path and the
+// files it includes
+func ParseSNMPdConfig(path string) ([]Extension, error) {
+	return parseSNMPdConfig(path, 0, make(map[string]bool))
+}
+
+func parseSNMPdConfig(path string, depth int, seen map[string]bool) ([]Extension, error) {
+	if depth > maxIncludeDepth {
+		return nil, fmt.Errorf("includes nested too deeply at %s", path)
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	if seen[path] {
+		return nil, nil
+	}
+	seen[path] = true
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var exts []Extension
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		src := fmt.Sprintf("%s:%d", path, n)
+		switch fields[0] {
+		case "pass", "pass_persist":
+			e, err := parsePassLine(fields)
+			if err != nil {
+				slog.Warn("ignoring invalid snmpd config line", "source", src, slog.Any("error", err))
+				continue
+			}
+			e.Source = src
+			exts = append(exts, e)
+		case "includeFile":
+			inc, err := parseSNMPdConfig(includePath(path, fields[1]), depth+1, seen)
+			if err != nil {
+				slog.Warn("failed to read included snmpd config", "source", src, slog.Any("error", err))
+			}
+			exts = append(exts, inc...)
+		case "includeDir":
+			files, _ := filepath.Glob(filepath.Join(includePath(path, fields[1]), "*.conf"))
+			for _, p := range files {
+				inc, err := parseSNMPdConfig(p, depth+1, seen)
+				if err != nil {
+					slog.Warn("failed to read included snmpd config", "source", src, slog.Any("error", err))
+				}
+				exts = append(exts, inc...)
+			}
+		}
+	}
+
+	return exts, s.Err()
+}
+
+// includePath resolves an include relative to the including file
+func includePath(from string, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(filepath.Dir(from), p)
+}
+
+// parsePassLine parses "pass [-p priority] OID PROG [ARGS]"
+func parsePassLine(fields []string) (Extension, error) {
+	e := Extension{Persist: fields[0] == "pass_persist"}
+	fields = fields[1:]
+	if len(fields) > 1 && fields[0] == "-p" {
+		p, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return e, fmt.Errorf("invalid priority %q", fields[1])
+		}
+		e.Priority = p
+		fields = fields[2:]
+	}
+	if len(fields) < 2 {
+		return e, errors.New("missing OID or program")
+	}
+
+	o, err := passpersist.NewOID(fields[0])
+	if err != nil {
+		return e, err
+	}
+	e.OID = o
+	e.Program = fields[1]
+	e.Args = fields[2:]
+	return e, nil
+}
+
+// ParseEOSExtensions parses the output of
+// 'show running-config | section snmp-server extension', lines look like
+// 'snmp-server extension .1.3.6.1.4.1.30065.4.226 flash:/vrf [one-shot]'
+func ParseEOSExtensions(lines []string) []Extension {
+	var exts []Extension
+	for _, l := range lines {
+		fields := strings.Fields(l)
+		if len(fields) < 4 || fields[0] != "snmp-server" || fields[1] != "extension" {
+			continue
+		}
+		o, err := passpersist.NewOID(fields[2])
+		if err != nil {
+			slog.Warn("ignoring invalid snmp-server extension", "line", l, slog.Any("error", err))
+			continue
+		}
+		exts = append(exts, Extension{
+			Persist: !(len(fields) > 4 && fields[4] == "one-shot"),
+			OID:     o,
+			Program: fields[3],
+			Source:  "running-config",
+		})
+	}
+	return exts
+}
+
+// canonicalPath maps flash: URLs and relative EOS paths onto the file
+// system and resolves symlinks so different spellings of the same program
+// compare equal
+func canonicalPath(p string, eos bool) string {
+	switch {
+	case strings.HasPrefix(p, "flash:"):
+		p = filepath.Join(eosFlashDir, strings.TrimPrefix(p, "flash:"))
+	case strings.HasPrefix(p, "file:"):
+		p = strings.TrimPrefix(p, "file:")
+	case eos && !filepath.IsAbs(p):
+		p = filepath.Join(eosFlashDir, p)
+	}
+	if a, err := filepath.Abs(p); err == nil {
+		p = a
+	}
+	if r, err := filepath.EvalSymlinks(p); err == nil {
+		p = r
+	}
+	return filepath.Clean(p)
+}
+
+// selfPaths returns the canonical paths this program may be registered as
+func selfPaths() []string {
+	paths := []string{canonicalPath(ProgPath(), false)}
+	if exe, err := os.Executable(); err == nil {
+		paths = append(paths, canonicalPath(exe, false))
+	}
+	return paths
+}
+
+// FindExtension returns the first extension that runs this program
+func FindExtension(exts []Extension) (*Extension, error) {
+	return findExtension(exts, "")
+}
+
+// FindExtensionNamed is like FindExtension for a multicall binary, the
+// extension must run this program through a symlink called name or with
+// name as its first argument
+func FindExtensionNamed(exts []Extension, name string) (*Extension, error) {
+	return findExtension(exts, name)
+}
+
+func findExtension(exts []Extension, name string) (*Extension, error) {
+	self := selfPaths()
+	for i, e := range exts {
+		if name != "" && filepath.Base(strings.TrimPrefix(e.Program, "flash:")) != name &&
+			(len(e.Args) == 0 || e.Args[0] != name) {
+			continue
+		}
+		p := canonicalPath(e.Program, e.Source == "running-config")
+		for _, s := range self {
+			if p == s {
+				slog.Debug("matched extension", "source", e.Source, "oid", e.OID, "program", e.Program)
+				return &exts[i], nil
+			}
+		}
+	}
+	return nil, errors.New("failed to find extension in snmpd config")
+}
+
+func snmpdConfigPaths() []string {
+	if v, ok := os.LookupEnv("SNMPCONFPATH"); ok {
+		var paths []string
+		for _, d := range filepath.SplitList(v) {
+			paths = append(paths, filepath.Join(d, "snmpd.conf"))
+		}
+		return paths
+	}
+	return SNMPdConfigPaths
+}
+
+// GetBaseOIDFromSNMPdConfig finds the OID this program is registered
+// under, in the EOS running-config when on a switch and in snmpd.conf
+// otherwise
+func GetBaseOIDFromSNMPdConfig() (*passpersist.OID, error) {
+	return getBaseOID("")
+}
+
+// GetBaseOIDForExtension finds the OID the extension name of a multicall
+// binary is registered under
+func GetBaseOIDForExtension(name string) (*passpersist.OID, error) {
+	return getBaseOID(name)
+}
+
+func getBaseOID(name string) (*passpersist.OID, error) {
+	if _, err := exec.LookPath("Cli"); err == nil {
+		out, err := arista.EosCommand("show running-config | section snmp-server extension")
+		if err != nil {
+			slog.Warn("failed to read snmp-server extensions", slog.Any("error", err))
+		} else if e, err := findExtension(ParseEOSExtensions(out), name); err == nil {
+			return &e.OID, nil
+		}
+	}
+
+	var exts []Extension
+	for _, p := range snmpdConfigPaths() {
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		e, err := ParseSNMPdConfig(p)
+		if err != nil {
+			slog.Warn("failed to parse snmpd config", "path", p, slog.Any("error", err))
+		}
+		exts = append(exts, e...)
+	}
+
+	e, err := findExtension(exts, name)
+	if err != nil {
+		return nil, err
+	}
+	return &e.OID, nil
+}
+
+// path option is only used for the test case
+func getBaseOIDFromSNMPdConfig(path string) (*passpersist.OID, error) {
+	exts, err := ParseSNMPdConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	e, err := FindExtension(exts)
+	if err != nil {
+		return nil, err
+	}
+	return &e.OID, nil
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arista-northwest/go-passpersist/passpersist"
+)
+
+func TestParseSNMPdConfigIncludes(t *testing.T) {
+	tmp := t.TempDir()
+	incDir := filepath.Join(tmp, "snmpd.conf.d")
+	if err := os.Mkdir(incDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"snmpd.conf": strings.Join([]string{
+			"# extensions",
+			"rocommunity public",
+			"pass .1.3.6.1.4.1.8072.2.255 /usr/bin/passtest arg1",
+			"includeFile extra.conf",
+			"includeDir snmpd.conf.d",
+			"pass_persist bogus /usr/bin/bogus",
+		}, "\n"),
+		"extra.conf":             "pass_persist -p 10 1.3.6.1.4.1.30065.4.226 /usr/bin/vrf # trailing\nincludeFile snmpd.conf\n",
+		"snmpd.conf.d/dhcp.conf": "pass_persist SNMPv2-SMI::experimental.53 ../dhcp\n",
+		"snmpd.conf.d/ignored":   "pass_persist 1.3.6.1.3.54 /usr/bin/ignored\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmp, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exts, err := ParseSNMPdConfig(filepath.Join(tmp, "snmpd.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		persist bool
+		oid     string
+		prog    string
+	}{
+		{false, "1.3.6.1.4.1.8072.2.255", "/usr/bin/passtest"},
+		{true, "1.3.6.1.4.1.30065.4.226", "/usr/bin/vrf"},
+		{true, "1.3.6.1.3.53", "../dhcp"},
+	}
+	if len(exts) != len(want) {
+		t.Fatalf("expected %d extensions, got %+v", len(want), exts)
+	}
+	for i, w := range want {
+		e := exts[i]
+		if e.Persist != w.persist || e.OID.String() != w.oid || e.Program != w.prog {
+			t.Errorf("extension %d: got %+v, wanted %+v", i, e, w)
+		}
+	}
+	if exts[0].Args[0] != "arg1" || exts[1].Priority != 10 {
+		t.Errorf("unexpected args or priority: %+v", exts)
+	}
+}
+
+func TestParseEOSExtensions(t *testing.T) {
+	out := []string{
+		"snmp-server extension .1.3.6.1.4.1.30065.4.226 flash:/vrf",
+		"snmp-server extension 1.3.6.1.3.53 dhcp one-shot",
+		"snmp-server engineID local 0000",
+	}
+	exts := ParseEOSExtensions(out)
+	if len(exts) != 2 {
+		t.Fatalf("expected 2 extensions, got %+v", exts)
+	}
+	if !exts[0].Persist || exts[1].Persist {
+		t.Errorf("one-shot extensions are not persistent: %+v", exts)
+	}
+	if got := canonicalPath(exts[0].Program, true); got != "/mnt/flash/vrf" {
+		t.Errorf("unexpected path for flash: url %s", got)
+	}
+	if got := canonicalPath(exts[1].Program, true); got != "/mnt/flash/dhcp" {
+		t.Errorf("unexpected path for relative program %s", got)
+	}
+}
+
+func TestFindExtensionSymlink(t *testing.T) {
+	tmp := t.TempDir()
+	link := filepath.Join(tmp, "extension")
+	if err := os.Symlink(ProgPath(), link); err != nil {
+		t.Skip(err)
+	}
+
+	want := passpersist.MustNewOID("1.3.6.1.4.1.30065.4.226")
+	e, err := FindExtension([]Extension{
+		{OID: passpersist.MustNewOID("1.3.6.1.3.1"), Program: "/nonexistent"},
+		{OID: want, Program: link},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.OID.Equal(want) {
+		t.Errorf("matched %s, wanted %s", e.OID, want)
+	}
+}
+
+func TestFindExtensionNamed(t *testing.T) {
+	tmp := t.TempDir()
+	link := filepath.Join(tmp, "vrf")
+	if err := os.Symlink(ProgPath(), link); err != nil {
+		t.Skip(err)
+	}
+
+	exts := []Extension{
+		{OID: passpersist.MustNewOID("1.3.6.1.3.1"), Program: ProgPath(), Args: []string{"hello"}},
+		{OID: passpersist.MustNewOID("1.3.6.1.3.2"), Program: link},
+		{OID: passpersist.MustNewOID("1.3.6.1.3.3"), Program: ProgPath(), Args: []string{"dhcp"}},
+	}
+	for name, want := range map[string]string{"hello": "1.3.6.1.3.1", "vrf": "1.3.6.1.3.2", "dhcp": "1.3.6.1.3.3"} {
+		e, err := FindExtensionNamed(exts, name)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if e.OID.String() != want {
+			t.Errorf("%s matched %s, wanted %s", name, e.OID, want)
+		}
+	}
+	if _, err := FindExtensionNamed(exts, "other"); err == nil {
+		t.Error("expected no match for an unconfigured extension")
+	}
+}
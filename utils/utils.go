@@ -1,23 +1,17 @@
 package utils
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/asn1"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"log/slog"
 	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 	"runtime"
 	"runtime/debug"
 	"time"
-
-	"github.com/arista-northwest/go-passpersist/passpersist"
 )
 
 func ProgName() string {
@@ -61,43 +55,6 @@ func Float64FromBytes(bytes []byte) float64 {
 	return float
 }
 
-func GetBaseOIDFromSNMPdConfig() (*passpersist.OID, error) {
-	return getBaseOIDFromSNMPdConfig("")
-}
-
-// path option is only used for the test case
-func getBaseOIDFromSNMPdConfig(path string) (*passpersist.OID, error) {
-	t := []string{"-Dread_config", "-H"}
-	if path != "" {
-		t = append(t, "-c", path)
-	}
-	cmd := exec.Command("snmpd", t...)
-	p, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, err
-	}
-
-	err = cmd.Start()
-	if err != nil {
-		return nil, err
-	}
-
-	re := regexp.MustCompile(`pass_persist ([\d\.]+) ` + regexp.QuoteMeta(ProgPath()))
-
-	s := bufio.NewScanner(p)
-	for s.Scan() {
-		b := s.Bytes()
-		if re.Match(b) {
-			g := re.FindSubmatch(b)
-			slog.Debug("matched pass_persist in snmpd config:", "line", string(b))
-			o, err := passpersist.NewOID(string(g[1]))
-			return &o, err
-		}
-	}
-
-	return nil, errors.New("failed to find extension in snmpd config")
-}
-
 func EncodeString(s string) []int {
 	b, _ := asn1.Marshal(s)
 	oid := make([]int, len(b))